@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/justinbarrick/civitas/pkg/util"
+	"log"
+	"time"
+)
+
+// watchAddress re-detects the advertise address periodically and sends the new
+// address on changed if it changes, for example after a DHCP renewal. Serf and
+// Raft cannot change their address while running, so the agent leaves the
+// cluster and exits to be restarted with the new address.
+func watchAddress(selector *util.AddressSelector, address string, interval time.Duration, changed chan<- string) {
+	for range time.Tick(interval) {
+		detected, err := selector.Select()
+		if err != nil {
+			log.Println("could not re-detect advertise address:", err)
+			continue
+		}
+
+		if detected != address {
+			log.Printf("advertise address changed from %s to %s, leaving the cluster to rejoin with the new address.\n", address, detected)
+			changed <- detected
+			return
+		}
+	}
+}
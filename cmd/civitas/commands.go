@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/justinbarrick/civitas/pkg/api"
+	"github.com/justinbarrick/civitas/pkg/cluster"
+	"github.com/justinbarrick/civitas/pkg/kubeadm"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// The environment variable the API token can be set with.
+const apiTokenEnv = "CIVITAS_API_TOKEN"
+
+var commands = map[string]func(args []string){
+	"agent":       agent,
+	"members":     members,
+	"status":      status,
+	"leave":       leave,
+	"force-leave": forceLeave,
+	"raft":        raftCommand,
+	"keyring":     keyring,
+	"upgrade":     upgrade,
+	"snapshot":    snapshot,
+	"help":        func(args []string) { usage() },
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: civitas <command> [flags] [args]
+
+Commands:
+    agent                     run the civitas agent, the default if no command is given
+    members                   list the members of the cluster
+    status                    show the status of this node and the cluster
+    leave                     gracefully leave the cluster
+    force-leave <node>        remove a failed member from the cluster
+    raft peers                list the raft peers
+    keyring                   list, install, use or remove gossip encryption keys
+    upgrade <version>         upgrade the cluster to a Kubernetes version
+    snapshot save <file>      save the replicated cluster state to a file
+    snapshot restore <file>   replicate a saved cluster state, run on the leader
+
+Run civitas <command> -h for the flags of a command.
+`)
+}
+
+// clientFlags adds the flags used to connect to the local agent, and returns a
+// function that creates the client once the flags are parsed.
+func clientFlags(flags *flag.FlagSet) func() *api.Client {
+	address := flags.String("api-address", envDefault("CIVITAS_API_ADDRESS", api.DefaultAddress), "the address of the agent's API.")
+	token := flags.String("api-token", os.Getenv(apiTokenEnv), "the API token, read from -data-dir if not set.")
+	dataDir := flags.String("data-dir", defaultDataDir, "the agent's data directory.")
+
+	return func() *api.Client {
+		if *token == "" && *dataDir != "" {
+			// Read requests do not need a token, so it is fine if it cannot be read.
+			data, err := ioutil.ReadFile(filepath.Join(*dataDir, apiTokenFile))
+			if err == nil {
+				*token = strings.TrimSpace(string(data))
+			}
+		}
+
+		return api.NewClient(*address, *token)
+	}
+}
+
+// parseClientFlags parses the flags of a client command and checks that it was
+// given the expected number of arguments.
+func parseClientFlags(flags *flag.FlagSet, args []string, numArgs int, argsUsage string) (*api.Client, []string) {
+	client := clientFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: civitas %s [flags] %s\n\nFlags:\n", flags.Name(), argsUsage)
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != numArgs {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	return client(), flags.Args()
+}
+
+func newTabWriter() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+}
+
+// formatTags formats tags as a sorted, comma separated list of key=value.
+func formatTags(tags map[string]string) string {
+	pairs := []string{}
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func members(args []string) {
+	flags := flag.NewFlagSet("members", flag.ExitOnError)
+	statusFilter := flags.String("status", "", "only list members with this status, e.g. alive or failed.")
+	client, _ := parseClientFlags(flags, args, 0, "")
+
+	members, err := client.Members()
+	if err != nil {
+		log.Fatal("could not list members: ", err)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+
+	w := newTabWriter()
+	fmt.Fprintln(w, "NAME\tADDRESS\tSTATUS\tROLE\tTAGS")
+	for _, member := range members {
+		if *statusFilter != "" && member.Status != *statusFilter {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s:%d\t%s\t%s\t%s\n", member.Name, member.Addr, member.Port, member.Status, member.Tags[cluster.RoleTag], formatTags(member.Tags))
+	}
+	w.Flush()
+}
+
+func status(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	client, _ := parseClientFlags(flags, args, 0, "")
+
+	node, err := client.Node()
+	if err != nil {
+		log.Fatal("could not get node status: ", err)
+	}
+
+	raftStatus, err := client.Raft()
+	if err != nil {
+		log.Fatal("could not get raft status: ", err)
+	}
+
+	state, err := client.State()
+	if err != nil {
+		log.Fatal("could not get cluster state: ", err)
+	}
+
+	ready := "yes"
+	if err := client.Ready(); err != nil {
+		ready = "no, " + err.Error()
+	}
+
+	leader := "none"
+	if raftStatus.Leader != "" {
+		leader = fmt.Sprintf("%s (%s)", raftStatus.Leader, raftStatus.LeaderAddress)
+	}
+
+	role := node.Role
+	if node.Leaving {
+		role += " (leaving)"
+	}
+
+	w := newTabWriter()
+	fmt.Fprintf(w, "Node:\t%s\n", node.Name)
+	fmt.Fprintf(w, "Kubernetes node:\t%s\n", node.KubernetesName)
+	fmt.Fprintf(w, "Address:\t%s\n", node.Addr)
+	fmt.Fprintf(w, "Role:\t%s\n", role)
+	fmt.Fprintf(w, "Ready:\t%s\n", ready)
+	fmt.Fprintf(w, "Raft state:\t%s\n", raftStatus.State)
+	fmt.Fprintf(w, "Raft leader:\t%s\n", leader)
+	fmt.Fprintf(w, "Masters:\t%s\n", strings.Join(state.Masters, ", "))
+	fmt.Fprintf(w, "VIP holder:\t%s\n", state.VIPHolder)
+	fmt.Fprintf(w, "Kubernetes version:\t%s\n", state.KubernetesVersion)
+	fmt.Fprintf(w, "civitas version:\t%s\n", node.Version)
+	w.Flush()
+}
+
+// leave asks the local agent to gracefully leave the cluster. If its API cannot
+// be reached, e.g. because it is disabled, the agent is sent SIGTERM instead.
+func leave(args []string) {
+	flags := flag.NewFlagSet("leave", flag.ExitOnError)
+	pidFile := flags.String("pid-file", defaultPidFile, "the agent's pid file, used to signal it if its API cannot be reached.")
+	client, _ := parseClientFlags(flags, args, 0, "")
+
+	err := client.Leave()
+	if _, unreachable := err.(*url.Error); unreachable && *pidFile != "" {
+		log.Println("could not reach the agent's API, sending it SIGTERM:", err)
+		err = signalPidFile(*pidFile, syscall.SIGTERM)
+	}
+
+	if err != nil {
+		log.Fatal("could not leave the cluster: ", err)
+	}
+
+	log.Println("civitas agent is leaving the cluster.")
+}
+
+func forceLeave(args []string) {
+	flags := flag.NewFlagSet("force-leave", flag.ExitOnError)
+	client, args := parseClientFlags(flags, args, 1, "<node>")
+
+	if err := client.ForceLeave(args[0]); err != nil {
+		log.Fatal("could not remove ", args[0], ": ", err)
+	}
+
+	log.Println("removed", args[0], "from the cluster.")
+}
+
+func raftCommand(args []string) {
+	if len(args) == 0 || args[0] != "peers" {
+		fmt.Fprintln(os.Stderr, "usage: civitas raft peers [flags]")
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("raft peers", flag.ExitOnError)
+	client, _ := parseClientFlags(flags, args[1:], 0, "")
+
+	raftStatus, err := client.Raft()
+	if err != nil {
+		log.Fatal("could not get raft status: ", err)
+	}
+
+	w := newTabWriter()
+	fmt.Fprintln(w, "NODE\tADDRESS\tSTATE\tSUFFRAGE")
+	for _, peer := range raftStatus.Peers {
+		state := "follower"
+		if peer.ID == raftStatus.Leader {
+			state = "leader"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", peer.ID, peer.Address, state, peer.Suffrage)
+	}
+	w.Flush()
+}
+
+func keyring(args []string) {
+	flags := flag.NewFlagSet("keyring", flag.ExitOnError)
+	install := flags.String("install", "", "install a new key on every member.")
+	use := flags.String("use", "", "make an installed key the primary key used to encrypt gossip.")
+	remove := flags.String("remove", "", "remove a key from every member, it must not be the primary key.")
+	client, _ := parseClientFlags(flags, args, 0, "")
+
+	operation, key := cluster.KeyringList, ""
+	switch {
+	case *install != "":
+		operation, key = cluster.KeyringInstall, *install
+	case *use != "":
+		operation, key = cluster.KeyringUse, *use
+	case *remove != "":
+		operation, key = cluster.KeyringRemove, *remove
+	}
+
+	response, err := client.Keyring(operation, key)
+	if err != nil {
+		log.Fatal("keyring ", operation, " failed: ", err)
+	}
+
+	if operation != cluster.KeyringList {
+		log.Printf("%s key on %d of %d members.\n", operation, response.NumResp-response.NumErr, response.NumNodes)
+		return
+	}
+
+	w := newTabWriter()
+	fmt.Fprintln(w, "KEY\tMEMBERS")
+	for key, count := range response.Keys {
+		fmt.Fprintf(w, "%s\t%d/%d\n", key, count, response.NumNodes)
+	}
+	w.Flush()
+}
+
+// upgrade sets the desired Kubernetes version, the nodes are then upgraded one at
+// a time.
+func upgrade(args []string) {
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	client, args := parseClientFlags(flags, args, 1, "<version>")
+
+	if err := client.SetDesiredVersion(args[0]); err != nil {
+		log.Fatal("could not set the desired Kubernetes version: ", err)
+	}
+
+	log.Println("desired Kubernetes version set to", args[0]+", the nodes will be upgraded one at a time.")
+}
+
+func snapshot(args []string) {
+	if len(args) == 0 || (args[0] != "save" && args[0] != "restore") {
+		fmt.Fprintln(os.Stderr, "usage: civitas snapshot save|restore [flags] <file>")
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("snapshot "+args[0], flag.ExitOnError)
+	client, paths := parseClientFlags(flags, args[1:], 1, "<file>")
+
+	if args[0] == "save" {
+		state, err := client.Snapshot()
+		if err != nil {
+			log.Fatal("could not save snapshot: ", err)
+		}
+
+		data, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Snapshots contain the bootstrap token and certificate key.
+		if err := ioutil.WriteFile(paths[0], append(data, '\n'), 0600); err != nil {
+			log.Fatal("could not save snapshot: ", err)
+		}
+
+		log.Println("saved cluster state to", paths[0])
+		return
+	}
+
+	data, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		log.Fatal("could not read snapshot: ", err)
+	}
+
+	state := kubeadm.State{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Fatal("invalid snapshot: ", err)
+	}
+
+	if err := client.Restore(state); err != nil {
+		log.Fatal("could not restore snapshot: ", err)
+	}
+
+	log.Println("restored cluster state from", paths[0])
+}
@@ -2,57 +2,270 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"github.com/justinbarrick/civitas/pkg/api"
 	"github.com/justinbarrick/civitas/pkg/cluster"
+	"github.com/justinbarrick/civitas/pkg/discovery"
+	"github.com/justinbarrick/civitas/pkg/drain"
+	"github.com/justinbarrick/civitas/pkg/echo"
+	"github.com/justinbarrick/civitas/pkg/executor"
 	"github.com/justinbarrick/civitas/pkg/kubeadm"
+	"github.com/justinbarrick/civitas/pkg/proxy"
+	"github.com/justinbarrick/civitas/pkg/serf"
 	"github.com/justinbarrick/civitas/pkg/util"
+	"github.com/justinbarrick/civitas/pkg/vip"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
+func splitList(list string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// listFlag is a flag that may be repeated, each value is appended to the list.
+// Values are not split on commas since kubelet arguments and taints may contain
+// them.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// envDefault returns the value of an environment variable, or def if it is unset.
+func envDefault(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return def
+}
+
+const (
+	defaultPidFile = "/var/run/civitas.pid"
+	defaultDataDir = "/var/lib/civitas"
+)
+
+func writePidFile(pidFile string) error {
+	return ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+}
+
+// signalPidFile sends a signal to the process whose pid is in pidFile.
+func signalPidFile(pidFile string, sig os.Signal) error {
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid file %s: %s", pidFile, err)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	return process.Signal(sig)
+}
+
 func main() {
+	command, args := "agent", os.Args[1:]
+
+	// The agent is run if no command is given, e.g. civitas -interface eth0.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	run, ok := commands[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n", command)
+		usage()
+		os.Exit(1)
+	}
+
+	run(args)
+}
+
+// agent runs the civitas agent.
+func agent(args []string) {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+
 	hostName, err := os.Hostname()
 	if err != nil {
 		log.Println("Warning: could not get hostname: ", err)
 	}
 
-	var numInitialNodes = flag.Int("initial-nodes", 3, "number of nodes to expect for bootstrapping")
-	var numMasterNodes = flag.Int("master-nodes", 3, "number of master nodes to maintain")
-	var address = flag.String("address", "", "the address of this node")
-	var iface = flag.String("interface", "", "the interface to advertise and bind to")
-	var port = flag.Int("port", 1234, "the port to bind to for p2p activity")
-	var nodeName = flag.String("name", hostName, "the identifier to use for this node")
-	var controlPlaneIP = flag.String("control-plane-ip", "127.0.13.37", "IP address to bind the control plane load balancer to on each node.")
-	var mdnsService = flag.String("mdns-service", os.Getenv("MDNS_SERVICE"), "The mDNS service to broadcast.")
-	flag.Parse()
+	var numInitialNodes = flags.Int("initial-nodes", 3, "number of nodes to expect for bootstrapping")
+	var numMasterNodes = flags.Int("master-nodes", 3, "number of master nodes to maintain")
+	var address = flags.String("address", "", "the address of this node, detected from -interface, -advertise-cidr or the default route if not set.")
+	var iface = flags.String("interface", "", "the interface to advertise and bind to, its address is only used if -address is not set.")
+	var advertiseCIDR = flags.String("advertise-cidr", "", "advertise the address in this CIDR, e.g. 10.0.0.0/8.")
+	var addressInterval = flags.Duration("address-check-interval", 30*time.Second, "how often to check whether a detected address has changed, 0 to disable.")
+	var port = flags.Int("port", 1234, "the port to bind to for p2p activity")
+	var bindAddress = flags.String("bind-address", "", "the address to listen on, defaults to the advertised address. Use 0.0.0.0 or :: behind NAT.")
+	var raftPort = flags.Int("raft-port", 0, "the port to bind Raft to, defaults to -port + 1.")
+	var lockPort = flags.Int("lock-port", 0, "the port to bind the bootstrap lock to, defaults to -port + 2.")
+	var advertisePort = flags.Int("advertise-port", 0, "the serf port advertised to peers, defaults to -port.")
+	var raftAdvertisePort = flags.Int("raft-advertise-port", 0, "the Raft port advertised to peers, defaults to -raft-port.")
+	var lockAdvertisePort = flags.Int("lock-advertise-port", 0, "the bootstrap lock port advertised to peers, defaults to -lock-port.")
+	var nodeName = flags.String("name", hostName, "the identifier to use for this node")
+	var controlPlaneIP = flags.String("control-plane-ip", "127.0.13.37", "IP address to bind the control plane load balancer to on each node.")
+	var mdnsService = flags.String("mdns-service", os.Getenv("MDNS_SERVICE"), "The mDNS service to broadcast.")
+	var dryRun = flags.Bool("dry-run", false, "log the kubeadm commands that would be run instead of running them.")
+	var kubeadmConfig = flags.String("kubeadm-config", "", "kubeadm configuration file to merge over the generated configuration.")
+	var ignorePreflightErrors = flags.String("ignore-preflight-errors", strings.Join(kubeadm.DefaultIgnorePreflightErrors, ","), "comma separated list of kubeadm preflight checks whose errors are ignored.")
+	var criSocket = flags.String("cri-socket", "", "path to the CRI socket to register the node with, e.g. /run/containerd/containerd.sock.")
+	var kubeletExtraArgs listFlag
+	flags.Var(&kubeletExtraArgs, "kubelet-extra-args", "an extra kubelet argument in the form key=value, may be repeated.")
+	var nodeTaints listFlag
+	flags.Var(&nodeTaints, "node-taints", "a taint to register the node with in the form key=value:Effect, may be repeated.")
+	var serfTags listFlag
+	flags.Var(&serfTags, "tag", "an additional serf tag to advertise in the form key=value, may be repeated.")
+	var kubernetesNodeName = flags.String("kubernetes-node-name", "", "name to register the Kubernetes node as, defaults to the hostname.")
+	var kubernetesVersion = flags.String("kubernetes-version", "", "the Kubernetes version to deploy when the cluster is bootstrapped, defaults to the version of kubeadm.")
+	var kubeconfig = flags.String("kubeconfig", strings.Join(drain.DefaultKubeconfigs, ","), "comma separated list of kubeconfigs to try when draining this node.")
+	var drainTimeout = flags.Duration("drain-timeout", 2*time.Minute, "how long to wait for pods to be evicted when draining this node.")
+	var pidFile = flags.String("pid-file", defaultPidFile, "file to write the pid to.")
+	var leaveTimeout = flags.Duration("leave-timeout", 5*time.Minute, "how long to wait for the master role to be handed off when leaving.")
+	var proxyBalancer = flags.String("proxy-balancer", proxy.RoundRobin, "control plane load balancing strategy: round-robin, least-connections or local-first.")
+	var statusAddress = flags.String("status-address", "127.0.0.1:9637", "address to serve the HTTP API, metrics and proxy status on, empty to disable.")
+	var apiToken = flags.String("api-token", os.Getenv(apiTokenEnv), "token required for API write requests, generated and stored in -data-dir if not set.")
+	var vipAddress = flags.String("vip", "", "floating virtual IP address to assign to one of the masters for external access to the control plane.")
+	var vipInterface = flags.String("vip-interface", "", "the interface to assign the virtual IP to, defaults to -interface.")
+	var proxyConfig = flags.String("proxy-config", "", "file declaring additional services to proxy on each node.")
+	var proxyDrainTimeout = flags.Duration("proxy-drain-timeout", 2*time.Minute, "how long connections to a removed master are left open before being closed.")
+	var dataDir = flags.String("data-dir", defaultDataDir, "directory to persist known peers, gossip encryption keys and the API token to, empty to disable.")
+	var clusterID = flags.String("cluster-id", envDefault("CLUSTER_ID", "civitas"), "identifier shared by every node in the cluster.")
+	var beaconAddress = flags.String("beacon", os.Getenv("BEACON_ADDRESS"), "UDP broadcast or multicast address to send discovery beacons to, e.g. 239.255.77.77:7947.")
+	var beaconKey = flags.String("beacon-key", os.Getenv("BEACON_KEY"), "shared key used to authenticate discovery beacons.")
+	var ipFamily = flags.String("ip-family", util.IPv4, "address family to prefer when detecting the address: ipv4 or ipv6.")
+	var podSubnet = flags.String("pod-subnet", "", "pod subnet, or comma separated IPv4 and IPv6 subnets for a dual-stack cluster.")
+	var serviceSubnet = flags.String("service-subnet", "", "service subnet, or comma separated IPv4 and IPv6 subnets for a dual-stack cluster.")
+	var gossipProfile = flags.String("gossip-profile", serf.ProfileLAN, "gossip timings to use: lan, or wan for nodes in different clouds or datacenters.")
+	var echoAddress = flags.String("echo-address", "", "address to answer public address echo requests on, e.g. :7950, empty to disable.")
+	var publicAddressEcho = flags.String("public-address-echo", "", "echo service (host:port) used to discover and advertise this node's public address.")
+	var encryptKey = flags.String("encrypt", os.Getenv("ENCRYPT_KEY"), "base64 encoded 32 byte key to encrypt gossip with, see civitas keyring.")
+	var discoveryHelp = flags.Bool("discovery-help", false, "print the discovery providers and their options.")
+	flags.Parse(args)
+
+	if *discoveryHelp {
+		fmt.Println(discovery.NewDiscover().Help())
+		return
+	}
+
+	addressChanged := make(chan string, 1)
+
+	if *address == "" {
+		selector := &util.AddressSelector{
+			Interface: *iface,
+			CIDR:      *advertiseCIDR,
+			Family:    *ipFamily,
+		}
 
-	if *iface != "" {
-		ipStr, err := util.GetIPForInterface(*iface)
+		ipStr, err := selector.Select()
 		if err != nil {
-			log.Fatal(err)
+			log.Fatal("could not detect the address to advertise, set -address, -interface or -advertise-cidr: ", err)
 		}
 		address = &ipStr
+
+		if *addressInterval > 0 {
+			go watchAddress(selector, *address, *addressInterval, addressChanged)
+		}
 	}
 
-	if *address == "" {
-		log.Fatal("address or interface must be specified.")
+	privateAddress := ""
+
+	if *publicAddressEcho != "" {
+		publicIP, err := echo.Query(*publicAddressEcho, []byte(*encryptKey), 2*time.Second)
+		if err != nil {
+			log.Fatal("could not discover public address: ", err)
+		}
+
+		if publicIP.String() != *address {
+			log.Println("discovered public address", publicIP, "for", *address)
+
+			privateAddress = *address
+			if *bindAddress == "" {
+				*bindAddress = privateAddress
+			}
+
+			*address = publicIP.String()
+		}
+	}
+
+	if *echoAddress != "" {
+		if err := echo.Serve(*echoAddress, []byte(*encryptKey)); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	log.Println("joining cluster as", *nodeName, "advertising", *address)
 
-	discoveryConfig := flag.Args()
+	discoveryConfig := flags.Args()
 	envDiscovery := os.Getenv("DISCOVERY_CONFIG")
 	if envDiscovery != "" {
 		discoveryConfig = append(discoveryConfig, strings.Split(envDiscovery, "\n")...)
 	}
 
+	tags := map[string]string{}
+	for _, tag := range serfTags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			log.Fatalf("invalid tag %q, expected key=value", tag)
+		}
+
+		tags[parts[0]] = parts[1]
+	}
+
 	cluster := &cluster.Cluster{
-		NodeName: *nodeName,
-		Addr: *address,
-		Port: *port,
-		NumInitialNodes: *numInitialNodes,
-		MDNSService: *mdnsService,
-		DiscoveryConfig: discoveryConfig,
+		NodeName:          *nodeName,
+		Addr:              *address,
+		Port:              *port,
+		BindAddr:          *bindAddress,
+		RaftPort:          *raftPort,
+		LockPort:          *lockPort,
+		AdvertisePort:     *advertisePort,
+		RaftAdvertisePort: *raftAdvertisePort,
+		LockAdvertisePort: *lockAdvertisePort,
+		PrivateAddr:       privateAddress,
+		GossipProfile:     *gossipProfile,
+		NumInitialNodes:   *numInitialNodes,
+		MDNSService:       *mdnsService,
+		DiscoveryConfig:   discoveryConfig,
+		DataDir:           *dataDir,
+		ClusterID:         *clusterID,
+		BeaconAddress:     *beaconAddress,
+		BeaconKey:         *beaconKey,
+		Interface:         *iface,
+		EncryptKey:        *encryptKey,
+		Tags:              tags,
+	}
+
+	if *statusAddress != "" {
+		if err := exportGoMetrics(); err != nil {
+			log.Println("Warning: could not export raft and serf metrics: ", err)
+		}
 	}
 
 	if err = cluster.Start(); err != nil {
@@ -60,7 +273,129 @@ func main() {
 	}
 
 	k := kubeadm.NewKubeadm(cluster, *controlPlaneIP)
+	if *dryRun {
+		k.SetExecutor(executor.NewDryRun())
+	} else {
+		k.SetDrainer(drain.NewDrainer(splitList(*kubeconfig), *drainTimeout))
+	}
+
+	if *kubernetesVersion != "" {
+		if err := k.SetKubernetesVersion(*kubernetesVersion); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := k.SetProxyBalancer(*proxyBalancer); err != nil {
+		log.Fatal(err)
+	}
+
+	k.SetProxyDrainTimeout(*proxyDrainTimeout)
+
+	if *proxyConfig != "" {
+		services, err := proxy.LoadServices(*proxyConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.AddProxyServices(services); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	k.SetIgnorePreflightErrors(splitList(*ignorePreflightErrors))
+
+	if err := k.SetNetworking(splitList(*podSubnet), splitList(*serviceSubnet)); err != nil {
+		log.Fatal(err)
+	}
+
+	err = k.SetNodeRegistration(kubeadm.NodeRegistration{
+		Name:             *kubernetesNodeName,
+		CRISocket:        *criSocket,
+		KubeletExtraArgs: kubeletExtraArgs,
+		Taints:           nodeTaints,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *kubeadmConfig != "" {
+		data, err := ioutil.ReadFile(*kubeadmConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.SetConfigOverlay(data); err != nil {
+			log.Fatal("invalid kubeadm configuration: ", err)
+		}
+	}
+
+	if *vipAddress != "" {
+		if *vipInterface == "" {
+			vipInterface = iface
+		}
+
+		var vipExecutor executor.Executor = executor.NewExec()
+		if *dryRun {
+			vipExecutor = executor.NewDryRun()
+		}
+
+		controlPlaneVIP, err := vip.NewVIP(*vipAddress, *vipInterface, vipExecutor)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		k.SetVIP(controlPlaneVIP)
+	}
+
+	if err := writePidFile(*pidFile); err != nil {
+		log.Println("Warning: could not write pid file: ", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	if *statusAddress != "" {
+		if *apiToken == "" {
+			token, err := loadAPIToken(*dataDir)
+			if err != nil {
+				log.Println("Warning: could not load API token, API write requests are disabled: ", err)
+			}
+			apiToken = &token
+		}
+
+		server := &api.Server{
+			Kubeadm: k,
+			Cluster: cluster,
+			Token:   *apiToken,
+			Leave: func() {
+				select {
+				case signals <- syscall.SIGTERM:
+				default:
+				}
+			},
+		}
+
+		go serveStatus(*statusAddress, k, server)
+	}
+
 	k.Controller(*numMasterNodes)
 
-	select{ }
+	restart := false
+
+	select {
+	case <-signals:
+	case <-addressChanged:
+		restart = true
+	}
+
+	if err := k.Leave(*leaveTimeout); err != nil {
+		log.Println("error leaving cluster:", err)
+	}
+
+	os.Remove(*pidFile)
+
+	// Exit with an error so that civitas is restarted with the new address.
+	if restart {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/armon/go-metrics"
+	gometrics "github.com/armon/go-metrics/prometheus"
+	"github.com/justinbarrick/civitas/pkg/api"
+	"github.com/justinbarrick/civitas/pkg/kubeadm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The file in the data directory that the generated API token is stored in.
+const apiTokenFile = "api-token"
+
+// serveStatus serves the HTTP API, Prometheus metrics and the status of the
+// proxied services.
+func serveStatus(address string, k *kubeadm.Kubeadm, server *api.Server) {
+	prometheus.MustRegister(k.Collectors()...)
+	prometheus.MustRegister(server.Cluster.Collectors()...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/proxy", k.Proxies().StatusHandler())
+	mux.Handle("/v1/", server.Handler())
+
+	log.Println("serving API and status at:", address)
+	log.Fatal(http.ListenAndServe(address, mux))
+}
+
+// exportGoMetrics exports the metrics that raft, serf and memberlist emit with
+// go-metrics to Prometheus, e.g. raft's commit and apply latency as
+// civitas_raft_commitTime and civitas_raft_fsm_apply.
+func exportGoMetrics() error {
+	sink, err := gometrics.NewPrometheusSink()
+	if err != nil {
+		return err
+	}
+
+	config := metrics.DefaultConfig("civitas")
+	config.EnableHostname = false
+	config.EnableRuntimeMetrics = false
+
+	_, err = metrics.NewGlobal(config, sink)
+	return err
+}
+
+// loadAPIToken returns the API token stored in the data directory, generating
+// one if there is none. No token is returned if there is no data directory.
+func loadAPIToken(dataDir string) (string, error) {
+	if dataDir == "" {
+		return "", nil
+	}
+
+	path := filepath.Join(dataDir, apiTokenFile)
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data)), nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return "", err
+	}
+
+	encoded := hex.EncodeToString(token)
+	return encoded, ioutil.WriteFile(path, []byte(encoded+"\n"), 0600)
+}
@@ -0,0 +1,398 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/justinbarrick/civitas/pkg/cluster"
+	"github.com/justinbarrick/civitas/pkg/kubeadm"
+	"github.com/justinbarrick/civitas/pkg/version"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// The value that secrets in the cluster state are replaced with for requests
+// without a valid token.
+const redacted = "<redacted>"
+
+// Server serves the civitas HTTP API. Read endpoints are open to anyone who can
+// reach the API, while write endpoints require the token.
+type Server struct {
+	Kubeadm *kubeadm.Kubeadm
+	Cluster *cluster.Cluster
+	// The token write requests must present as "Authorization: Bearer <token>".
+	// Write requests are refused if it is empty.
+	Token string
+	// Called to make this node gracefully leave the cluster.
+	Leave func()
+}
+
+// Member is a cluster member as returned by /v1/members.
+type Member struct {
+	Name   string            `json:"name"`
+	Addr   string            `json:"addr"`
+	Port   uint16            `json:"port"`
+	Status string            `json:"status"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// Node describes this node as returned by /v1/node.
+type Node struct {
+	Name           string `json:"name"`
+	KubernetesName string `json:"kubernetes_name"`
+	Addr           string `json:"addr"`
+	// The role this node has been started as, empty until it has received the
+	// cluster state.
+	Role      string `json:"role"`
+	Leader    bool   `json:"leader"`
+	Leaving   bool   `json:"leaving"`
+	VIPHolder bool   `json:"vip_holder"`
+	Version   string `json:"version"`
+}
+
+// DesiredVersion is the body of a /v1/desired-version request.
+type DesiredVersion struct {
+	Version string `json:"version"`
+}
+
+// ForceLeave is the body of a /v1/force-leave request.
+type ForceLeave struct {
+	Node string `json:"node"`
+}
+
+// KeyringRequest is the body of a /v1/keyring request.
+type KeyringRequest struct {
+	// One of install, use or remove.
+	Operation string `json:"operation"`
+	// The base64 encoded key.
+	Key string `json:"key"`
+}
+
+// KeyringResponse is the result of a keyring operation.
+type KeyringResponse struct {
+	// The number of members that have each key installed.
+	Keys     map[string]int    `json:"keys,omitempty"`
+	NumNodes int               `json:"num_nodes"`
+	NumResp  int               `json:"num_responses"`
+	NumErr   int               `json:"num_errors"`
+	Messages map[string]string `json:"messages,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Error is the body of an error response.
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the handler for all API endpoints.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", s.method("GET", s.health))
+	mux.HandleFunc("/v1/health/ready", s.method("GET", s.ready))
+	mux.HandleFunc("/v1/members", s.method("GET", s.members))
+	mux.HandleFunc("/v1/raft", s.method("GET", s.raft))
+	mux.HandleFunc("/v1/state", s.method("GET", s.state))
+	mux.HandleFunc("/v1/node", s.method("GET", s.node))
+	mux.HandleFunc("/v1/leave", s.method("POST", s.authorize(s.leave)))
+	mux.HandleFunc("/v1/desired-version", s.method("POST", s.authorize(s.desiredVersion)))
+	mux.HandleFunc("/v1/force-leave", s.method("POST", s.authorize(s.forceLeave)))
+	mux.HandleFunc("/v1/keyring", s.authorize(s.keyring))
+	mux.HandleFunc("/v1/snapshot", s.authorize(s.snapshot))
+	return mux
+}
+
+// method only allows requests with the given method to reach next.
+func (s *Server) method(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// authorized returns true if the request presents the token.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Token == "" {
+		return false
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// authorize only allows requests that present the token to reach next.
+func (s *Server) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token == "" {
+			writeError(w, http.StatusForbidden, fmt.Errorf("write requests are disabled, no API token is configured"))
+			return
+		}
+
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("a valid API token is required"))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(obj); err != nil {
+		log.Println("error writing API response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Error{err.Error()})
+}
+
+// health reports whether the agent is running.
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ready reports whether there is a raft leader and this node has received the
+// cluster state.
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	status, err := s.Cluster.RaftStatus()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	if status.LeaderAddress == "" {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("there is no raft leader"))
+		return
+	}
+
+	if s.Kubeadm.CurrentRole() == "" {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("the cluster state has not been received"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *Server) members(w http.ResponseWriter, r *http.Request) {
+	members := []Member{}
+
+	for _, member := range s.Cluster.Members() {
+		members = append(members, Member{
+			Name:   member.Name,
+			Addr:   member.Addr.String(),
+			Port:   member.Port,
+			Status: member.Status.String(),
+			Tags:   member.Tags,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, members)
+}
+
+func (s *Server) raft(w http.ResponseWriter, r *http.Request) {
+	status, err := s.Cluster.RaftStatus()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// state returns the replicated cluster state. The bootstrap token and
+// certificate key are redacted unless the request presents the API token.
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	state := s.Kubeadm.State()
+
+	if !s.authorized(r) {
+		if state.Token != "" {
+			state.Token = redacted
+		}
+
+		if state.CertificateKey != "" {
+			state.CertificateKey = redacted
+		}
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (s *Server) node(w http.ResponseWriter, r *http.Request) {
+	state := s.Kubeadm.State()
+
+	writeJSON(w, http.StatusOK, Node{
+		Name:           s.Cluster.NodeName,
+		KubernetesName: s.Kubeadm.NodeName(),
+		Addr:           s.Cluster.Addr,
+		Role:           s.Kubeadm.CurrentRole(),
+		Leader:         s.Cluster.Leader(),
+		Leaving:        s.Kubeadm.Leaving(),
+		VIPHolder:      state.VIPHolder != "" && state.VIPHolder == s.Cluster.NodeName,
+		Version:        version.Version,
+	})
+}
+
+// leave starts gracefully leaving the cluster and returns immediately.
+func (s *Server) leave(w http.ResponseWriter, r *http.Request) {
+	if s.Kubeadm.Leaving() {
+		writeError(w, http.StatusConflict, fmt.Errorf("already leaving the cluster"))
+		return
+	}
+
+	log.Println("leave requested through the API.")
+	s.Leave()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "leaving"})
+}
+
+// desiredVersion sets the Kubernetes version that the cluster should run. The
+// request is accepted once the version is replicated, the nodes are upgraded to
+// it in the background.
+func (s *Server) desiredVersion(w http.ResponseWriter, r *http.Request) {
+	desired := DesiredVersion{}
+	if err := json.NewDecoder(r.Body).Decode(&desired); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+		return
+	}
+
+	if err := s.Kubeadm.SetDesiredVersion(desired.Version); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, desired)
+}
+
+// forceLeave removes a failed member from the cluster.
+func (s *Server) forceLeave(w http.ResponseWriter, r *http.Request) {
+	request := ForceLeave{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+		return
+	}
+
+	if request.Node == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("a node is required"))
+		return
+	}
+
+	if err := s.Cluster.ForceLeave(request.Node); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, request)
+}
+
+// keyring lists the gossip encryption keys on GET, and installs, uses or removes
+// a key on POST.
+func (s *Server) keyring(w http.ResponseWriter, r *http.Request) {
+	request := KeyringRequest{Operation: cluster.KeyringList}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+			return
+		}
+
+		if request.Operation == cluster.KeyringList || request.Key == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("an operation and key are required"))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	keyResponse, err := s.Cluster.Keyring(request.Operation, request.Key)
+	if keyResponse == nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := KeyringResponse{
+		Keys:     keyResponse.Keys,
+		NumNodes: keyResponse.NumNodes,
+		NumResp:  keyResponse.NumResp,
+		NumErr:   keyResponse.NumErr,
+		Messages: keyResponse.Messages,
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		response.Error = err.Error()
+		status = http.StatusInternalServerError
+
+		// Include the reasons the members failed, e.g. that encryption is not
+		// enabled.
+		reasons := map[string]bool{}
+		for _, message := range keyResponse.Messages {
+			if message != "" && !reasons[message] {
+				reasons[message] = true
+				response.Error += ": " + message
+			}
+		}
+	}
+
+	writeJSON(w, status, response)
+}
+
+// snapshot returns the replicated cluster state on GET and restores it on POST.
+// Snapshots include the bootstrap token and certificate key.
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, s.Kubeadm.State())
+	case "POST":
+		state := kubeadm.State{}
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid snapshot: %s", err))
+			return
+		}
+
+		err := s.Kubeadm.RestoreState(state)
+		if err == cluster.ErrNotLeader {
+			writeError(w, http.StatusConflict, s.notLeader())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"status": "restored"})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// notLeader returns an error naming the leader that a request must be sent to.
+func (s *Server) notLeader() error {
+	status, err := s.Cluster.RaftStatus()
+	if err != nil || status.Leader == "" {
+		return cluster.ErrNotLeader
+	}
+
+	return fmt.Errorf("%s, send the request to the leader %s", cluster.ErrNotLeader, status.Leader)
+}
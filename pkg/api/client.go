@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/justinbarrick/civitas/pkg/cluster"
+	"github.com/justinbarrick/civitas/pkg/kubeadm"
+	"github.com/justinbarrick/civitas/pkg/raft"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultAddress is the address the API is served on by default.
+const DefaultAddress = "127.0.0.1:9637"
+
+// Client makes requests to the API of a civitas agent.
+type Client struct {
+	// The address of the API, with or without an http:// prefix.
+	Address string
+	// The token to authenticate requests with.
+	Token  string
+	client *http.Client
+}
+
+func NewClient(address, token string) *Client {
+	return &Client{
+		Address: address,
+		Token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// url returns the URL of an API path.
+func (c *Client) url(path string) string {
+	address := c.Address
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "http://" + address
+	}
+
+	return strings.TrimSuffix(address, "/") + path
+}
+
+// call makes a request with obj as the JSON body, if it is not nil, and decodes
+// the response into result, if it is not nil.
+func (c *Client) call(method, path string, obj interface{}, result interface{}) error {
+	var body io.Reader
+	if obj != nil {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.url(path), body)
+	if err != nil {
+		return err
+	}
+
+	if obj != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := Error{}
+		if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+
+		return fmt.Errorf("%s", apiErr.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// Ready returns nil if the agent has a raft leader and has received the cluster
+// state, or the reason it is not ready.
+func (c *Client) Ready() error {
+	return c.call("GET", "/v1/health/ready", nil, nil)
+}
+
+func (c *Client) Members() ([]Member, error) {
+	members := []Member{}
+	return members, c.call("GET", "/v1/members", nil, &members)
+}
+
+func (c *Client) Raft() (raft.Status, error) {
+	status := raft.Status{}
+	return status, c.call("GET", "/v1/raft", nil, &status)
+}
+
+func (c *Client) State() (kubeadm.State, error) {
+	state := kubeadm.State{}
+	return state, c.call("GET", "/v1/state", nil, &state)
+}
+
+func (c *Client) Node() (Node, error) {
+	node := Node{}
+	return node, c.call("GET", "/v1/node", nil, &node)
+}
+
+func (c *Client) Leave() error {
+	return c.call("POST", "/v1/leave", nil, nil)
+}
+
+func (c *Client) ForceLeave(name string) error {
+	return c.call("POST", "/v1/force-leave", ForceLeave{name}, nil)
+}
+
+func (c *Client) SetDesiredVersion(kubeVersion string) error {
+	return c.call("POST", "/v1/desired-version", DesiredVersion{kubeVersion}, nil)
+}
+
+// Keyring lists the gossip encryption keys if operation is list, and otherwise
+// installs, uses or removes key on every member.
+func (c *Client) Keyring(operation, key string) (KeyringResponse, error) {
+	response := KeyringResponse{}
+
+	if operation == cluster.KeyringList {
+		return response, c.call("GET", "/v1/keyring", nil, &response)
+	}
+
+	return response, c.call("POST", "/v1/keyring", KeyringRequest{operation, key}, &response)
+}
+
+// Snapshot returns the replicated cluster state, including its secrets.
+func (c *Client) Snapshot() (kubeadm.State, error) {
+	state := kubeadm.State{}
+	return state, c.call("GET", "/v1/snapshot", nil, &state)
+}
+
+// Restore replicates a saved cluster state, the agent must be the raft leader.
+func (c *Client) Restore(state kubeadm.State) error {
+	return c.call("POST", "/v1/snapshot", state, nil)
+}
@@ -0,0 +1,262 @@
+package beacon
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval is how often a beacon is sent.
+	DefaultInterval = 5 * time.Second
+	// Announcements older or newer than this are rejected to limit replays.
+	maxClockSkew = 2 * time.Minute
+	// Peers are returned until they have missed this many announcements.
+	missedAnnouncements = 3
+	maxPacketSize       = 1500
+	// Rejected senders are only logged once, the set of senders is forgotten
+	// after this long or once it reaches maxRejected so that it cannot grow
+	// without bound.
+	rejectedResetInterval = 10 * time.Minute
+	maxRejected           = 1024
+)
+
+// announcement is the payload of a beacon packet. On the wire it is prefixed
+// with an HMAC-SHA256 of the payload using the shared key.
+type announcement struct {
+	ClusterID string `json:"cluster"`
+	Name      string `json:"name"`
+	Addr      string `json:"addr"`
+	Time      int64  `json:"time"`
+}
+
+// Beacon announces this node's serf address to a UDP broadcast or multicast
+// address and listens for the announcements of other nodes in the same cluster.
+// Announcements are authenticated with a shared key so that nodes outside the
+// cluster cannot inject peers.
+type Beacon struct {
+	ClusterID string
+	Name      string
+	// The serf address to announce.
+	Addr string
+	// The broadcast or multicast address and port to send to, e.g.
+	// 255.255.255.255:7947, 239.255.77.77:7947 or [ff02::7947]:7947.
+	Group *net.UDPAddr
+	// The interface to send and listen on, required for multicast.
+	Interface *net.Interface
+	Interval  time.Duration
+	key       []byte
+	conn      *net.UDPConn
+	mutex     sync.Mutex
+	peers     map[string]time.Time
+}
+
+func NewBeacon(clusterID, name, addr, group, iface, key string) (*Beacon, error) {
+	if key == "" {
+		return nil, errors.New("a beacon key is required")
+	}
+
+	if clusterID == "" {
+		return nil, errors.New("a cluster ID is required")
+	}
+
+	groupAddr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, fmt.Errorf("invalid beacon address %s: %s", group, err)
+	}
+
+	if groupAddr.IP.To4() == nil && !groupAddr.IP.IsMulticast() {
+		return nil, fmt.Errorf("invalid beacon address %s: IPv6 beacons must use a multicast address", group)
+	}
+
+	b := &Beacon{
+		ClusterID: clusterID,
+		Name:      name,
+		Addr:      addr,
+		Group:     groupAddr,
+		Interval:  DefaultInterval,
+		key:       []byte(key),
+		peers:     map[string]time.Time{},
+	}
+
+	if iface != "" {
+		b.Interface, err = net.InterfaceByName(iface)
+		if err != nil {
+			return nil, fmt.Errorf("invalid beacon interface %s: %s", iface, err)
+		}
+	}
+
+	if b.Group.IP.IsMulticast() && b.Interface == nil {
+		return nil, errors.New("an interface is required for multicast beacons")
+	}
+
+	return b, nil
+}
+
+// Start opens the beacon socket and starts sending and receiving announcements.
+func (b *Beacon) Start() error {
+	var err error
+
+	if b.Group.IP.IsMulticast() {
+		b.conn, err = net.ListenMulticastUDP("udp", b.Interface, b.Group)
+		if err != nil {
+			return err
+		}
+
+		if b.Group.IP.To4() != nil {
+			err = ipv4.NewPacketConn(b.conn).SetMulticastInterface(b.Interface)
+		} else {
+			err = ipv6.NewPacketConn(b.conn).SetMulticastInterface(b.Interface)
+		}
+
+		if err != nil {
+			return err
+		}
+	} else {
+		b.conn, err = net.ListenUDP("udp4", &net.UDPAddr{Port: b.Group.Port})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("sending discovery beacons to %s\n", b.Group)
+
+	go b.send()
+	go b.receive()
+	return nil
+}
+
+func (b *Beacon) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (b *Beacon) send() {
+	for {
+		payload, err := json.Marshal(announcement{
+			ClusterID: b.ClusterID,
+			Name:      b.Name,
+			Addr:      b.Addr,
+			Time:      time.Now().Unix(),
+		})
+		if err != nil {
+			log.Println("could not encode beacon:", err)
+			return
+		}
+
+		if _, err := b.conn.WriteToUDP(append(b.sign(payload), payload...), b.Group); err != nil {
+			log.Println("could not send beacon:", err)
+		}
+
+		time.Sleep(b.Interval)
+	}
+}
+
+func (b *Beacon) receive() {
+	buf := make([]byte, maxPacketSize)
+	rejected := map[string]bool{}
+	rejectedReset := time.Now()
+
+	for {
+		n, from, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Println("error reading beacon:", err)
+			return
+		}
+
+		a, err := b.verify(buf[:n])
+		if err != nil {
+			if len(rejected) >= maxRejected || time.Since(rejectedReset) > rejectedResetInterval {
+				rejected = map[string]bool{}
+				rejectedReset = time.Now()
+			}
+
+			if !rejected[from.IP.String()] {
+				rejected[from.IP.String()] = true
+				log.Printf("ignoring beacons from %s: %s\n", from, err)
+			}
+			continue
+		}
+
+		if a == nil {
+			continue
+		}
+
+		b.mutex.Lock()
+		if _, ok := b.peers[a.Addr]; !ok {
+			log.Printf("received beacon from %s (%s)\n", a.Name, a.Addr)
+		}
+		b.peers[a.Addr] = time.Now()
+		b.mutex.Unlock()
+	}
+}
+
+// verify authenticates a packet and returns its announcement, or nil if it is
+// this node's own announcement or belongs to another cluster.
+func (b *Beacon) verify(packet []byte) (*announcement, error) {
+	if len(packet) <= sha256.Size {
+		return nil, errors.New("packet too short")
+	}
+
+	mac, payload := packet[:sha256.Size], packet[sha256.Size:]
+	if !hmac.Equal(mac, b.sign(payload)) {
+		return nil, errors.New("invalid signature")
+	}
+
+	a := &announcement{}
+	if err := json.Unmarshal(payload, a); err != nil {
+		return nil, err
+	}
+
+	if a.ClusterID != b.ClusterID || a.Name == b.Name {
+		return nil, nil
+	}
+
+	skew := time.Since(time.Unix(a.Time, 0))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, fmt.Errorf("announcement time is %s off", skew)
+	}
+
+	if _, _, err := net.SplitHostPort(a.Addr); err != nil {
+		return nil, fmt.Errorf("invalid address: %s", err)
+	}
+
+	return a, nil
+}
+
+func (b *Beacon) Help() string {
+	return `Beacon:
+
+    provider: "beacon"
+
+    Returns the peers heard from on the beacon address, configured with the
+    -beacon, -beacon-key and -cluster-id flags.
+`
+}
+
+// Addrs returns the serf addresses of peers that have recently sent beacons.
+func (b *Beacon) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	addrs := []string{}
+	for addr, seen := range b.peers {
+		if time.Since(seen) > missedAnnouncements*b.Interval {
+			delete(b.peers, addr)
+			continue
+		}
+
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
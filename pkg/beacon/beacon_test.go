@@ -0,0 +1,109 @@
+package beacon
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func newTestBeacon(t *testing.T) *Beacon {
+	b, err := NewBeacon("test-cluster", "node-1", "10.0.0.1:7946", "255.255.255.255:7947", "", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// packet returns a signed beacon packet for the announcement.
+func packet(t *testing.T, b *Beacon, a announcement) []byte {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(b.sign(payload), payload...)
+}
+
+func TestVerify(t *testing.T) {
+	b := newTestBeacon(t)
+
+	other, err := NewBeacon("test-cluster", "node-2", "10.0.0.2:7946", "255.255.255.255:7947", "", "other-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	valid := announcement{ClusterID: "test-cluster", Name: "node-2", Addr: "10.0.0.2:7946", Time: now}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		// The announcement expected to be accepted, nil if it is ignored.
+		accepted *announcement
+		err      bool
+	}{
+		{
+			name:     "valid",
+			packet:   packet(t, b, valid),
+			accepted: &valid,
+		},
+		{
+			name:   "too short",
+			packet: []byte("short"),
+			err:    true,
+		},
+		{
+			name:   "signed with another key",
+			packet: packet(t, other, valid),
+			err:    true,
+		},
+		{
+			name: "tampered",
+			packet: func() []byte {
+				p := packet(t, b, valid)
+				p[len(p)-2] ^= 1
+				return p
+			}(),
+			err: true,
+		},
+		{
+			name:   "too old",
+			packet: packet(t, b, announcement{ClusterID: "test-cluster", Name: "node-2", Addr: "10.0.0.2:7946", Time: now - 3600}),
+			err:    true,
+		},
+		{
+			name:   "too far in the future",
+			packet: packet(t, b, announcement{ClusterID: "test-cluster", Name: "node-2", Addr: "10.0.0.2:7946", Time: now + 3600}),
+			err:    true,
+		},
+		{
+			name:   "invalid address",
+			packet: packet(t, b, announcement{ClusterID: "test-cluster", Name: "node-2", Addr: "10.0.0.2", Time: now}),
+			err:    true,
+		},
+		{
+			name:   "another cluster",
+			packet: packet(t, b, announcement{ClusterID: "other-cluster", Name: "node-2", Addr: "10.0.0.2:7946", Time: now}),
+		},
+		{
+			name:   "own announcement",
+			packet: packet(t, b, announcement{ClusterID: "test-cluster", Name: "node-1", Addr: "10.0.0.1:7946", Time: now}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := b.verify(test.packet)
+			if test.err != (err != nil) {
+				t.Fatalf("expected error %v, got: %v", test.err, err)
+			}
+
+			if test.accepted == nil && a != nil {
+				t.Errorf("expected the announcement to be ignored, got: %+v", a)
+			} else if test.accepted != nil && (a == nil || *a != *test.accepted) {
+				t.Errorf("expected announcement %+v, got: %+v", test.accepted, a)
+			}
+		})
+	}
+}
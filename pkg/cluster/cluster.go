@@ -1,52 +1,169 @@
 package cluster
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"github.com/hashicorp/go-discover"
 	hserf "github.com/hashicorp/serf/serf"
+	"github.com/justinbarrick/civitas/pkg/beacon"
+	"github.com/justinbarrick/civitas/pkg/discovery"
 	"github.com/justinbarrick/civitas/pkg/lock"
 	"github.com/justinbarrick/civitas/pkg/raft"
 	"github.com/justinbarrick/civitas/pkg/serf"
-	"log"
+	"github.com/justinbarrick/civitas/pkg/util"
+	"github.com/justinbarrick/civitas/pkg/version"
 	"io/ioutil"
+	"log"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"time"
-	"github.com/hashicorp/go-discover"
-	"github.com/hashicorp/mdns"
 )
 
 type Cluster struct {
-	Port            int
-	Addr            string
+	// The serf port, the Raft and lock ports default to the two following ports.
+	Port int
+	// The address advertised to peers.
+	Addr string
+	// The address to listen on, defaults to Addr.
+	BindAddr string
+	RaftPort int
+	LockPort int
+	// The ports advertised to peers, which differ from the ports listened on
+	// behind NAT. Each defaults to the port listened on.
+	AdvertisePort     int
+	RaftAdvertisePort int
+	LockAdvertisePort int
+	// The address of this node on its own network when Addr is a public address.
+	// Members on the same network segment connect to each other on it.
+	PrivateAddr string
+	// The serf gossip profile: lan, wan or local.
+	GossipProfile   string
 	NodeName        string
 	NumInitialNodes int
 	MDNSService     string
 	DiscoveryConfig []string
-	raft            *raft.Raft
-	serf            *serf.Serf
-	lock            *lock.Lock
+	DataDir         string
+	ClusterID       string
+	BeaconAddress   string
+	BeaconKey       string
+	// The interface to send and answer discovery queries on.
+	Interface string
+	// The base64 encoded key to encrypt gossip with, gossip is not encrypted if
+	// it is empty and there is no keyring in DataDir.
+	EncryptKey string
+	// Additional serf tags to advertise, e.g. to select the node as a proxy
+	// upstream. They may not set the tags civitas sets itself.
+	Tags             map[string]string
+	raft             *raft.Raft
+	serf             *serf.Serf
+	lock             *lock.Lock
+	beacon           *beacon.Beacon
+	mdns             *discovery.MDNSAnnouncer
+	memberCh         chan bool
+	requestCh        chan *hserf.Query
+	discoveryMetrics *discoveryMetrics
+}
+
+const (
+	// LeavingTag is the serf tag set on a node that is gracefully leaving the
+	// cluster.
+	LeavingTag = "leaving"
+	// RoleTag is the serf tag advertising a node's Kubernetes role.
+	RoleTag = "role"
+)
+
+// Keyring operations.
+const (
+	KeyringList    = "list"
+	KeyringInstall = "install"
+	KeyringUse     = "use"
+	KeyringRemove  = "remove"
+)
+
+// How long to wait for the leader to respond to a forwarded request. The leader
+// must commit the change to raft before it responds.
+const forwardTimeout = 15 * time.Second
+
+// ErrNotLeader is returned by operations that must be run on the raft leader.
+var ErrNotLeader = errors.New("this node is not the raft leader")
+
+// Discovery is retried with an exponential backoff between these intervals while
+// it finds no new peers.
+const (
+	minDiscoveryInterval = 2 * time.Second
+	maxDiscoveryInterval = time.Minute
+	// How often peers files are checked for changes.
+	peersFileInterval = 10 * time.Second
+)
+
+// initialTags returns the serf tags this node starts with: Tags and the port
+// tags.
+func (c *Cluster) initialTags() (map[string]string, error) {
+	tags := c.portTags()
+
+	for key, value := range c.Tags {
+		switch key {
+		case RoleTag, LeavingTag, RaftPortTag, LockPortTag, PrivateAddrTag:
+			return nil, fmt.Errorf("the %s tag is set by civitas and cannot be overridden", key)
+		}
+
+		tags[key] = value
+	}
+
+	return tags, nil
 }
 
 func (c *Cluster) Start() error {
 	var err error
 
-	serfPort := c.Port
-	raftPort := c.Port + 1
-	dsyncPort := c.Port + 2
+	c.setDefaults()
+	c.discoveryMetrics = newDiscoveryMetrics()
+
+	tags, err := c.initialTags()
+	if err != nil {
+		return err
+	}
 
-	c.raft, err = raft.NewRaft(c.NodeName, c.Addr, int(raftPort))
+	c.raft, err = raft.NewRaft(c.NodeName, c.bindAddr(c.RaftPort), c.advertiseAddr(c.RaftAdvertisePort))
 	if err != nil {
 		return err
 	}
 
-	c.serf = serf.NewSerf(c.NodeName, c.Addr, int(serfPort))
+	c.serf = serf.NewSerf(c.NodeName, c.Addr, c.AdvertisePort)
+	c.serf.BindAddr = c.BindAddr
+	c.serf.BindPort = c.Port
+	c.serf.Tags = tags
+	c.serf.Profile = c.GossipProfile
 	c.serf.JoinCallback = c.JoinCallback
+	c.serf.MemberCallback = c.MemberCallback
+	c.serf.QueryCallback = c.QueryCallback
+	c.serf.ExpectedMembers = c.NumInitialNodes
+
+	if c.DataDir != "" {
+		if err := os.MkdirAll(c.DataDir, 0700); err != nil {
+			return err
+		}
 
-	rpcAddr := fmt.Sprintf("%s:%d", c.Addr, dsyncPort)
+		c.serf.SnapshotPath = filepath.Join(c.DataDir, "serf.snapshot")
+		c.serf.KeyringFile = filepath.Join(c.DataDir, "serf.keyring")
+	}
 
-	c.lock = lock.NewLock(rpcAddr, c.NumInitialNodes)
-	c.lock.AddNode(lock.NewClient(rpcAddr))
+	if c.EncryptKey != "" {
+		c.serf.EncryptKey, err = base64.StdEncoding.DecodeString(c.EncryptKey)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key: %s", err)
+		}
+	}
+
+	c.memberCh = make(chan bool, 1)
+	c.requestCh = make(chan *hserf.Query, 16)
+
+	c.lock = lock.NewLock(c.bindAddr(c.LockPort), c.NumInitialNodes)
+	c.lock.AddNode(lock.NewClient(c.bindAddr(c.LockPort)))
 
 	if err := c.serf.Start(); err != nil {
 		return err
@@ -56,6 +173,19 @@ func (c *Cluster) Start() error {
 		return err
 	}
 
+	if c.BeaconAddress != "" {
+		serfAddr := c.advertiseAddr(c.AdvertisePort)
+
+		c.beacon, err = beacon.NewBeacon(c.ClusterID, c.NodeName, serfAddr, c.BeaconAddress, c.Interface, c.BeaconKey)
+		if err != nil {
+			return err
+		}
+
+		if err := c.beacon.Start(); err != nil {
+			return err
+		}
+	}
+
 	go c.DiscoverNodes()
 	go c.serf.Join()
 
@@ -65,12 +195,15 @@ func (c *Cluster) Start() error {
 func (c *Cluster) JoinCallback(event hserf.MemberEvent) {
 	if !c.raft.Bootstrapped() {
 		for _, member := range c.serf.Members() {
-			memberRpcAddr := fmt.Sprintf("%s:%d", member.Addr.String(), member.Port+2)
-			c.lock.AddNode(lock.NewClient(memberRpcAddr))
+			if member.Name == c.NodeName {
+				continue
+			}
+
+			c.lock.AddNode(lock.NewClient(MemberLockAddr(member)))
 		}
 
 		lockAcquired, err := c.lock.Lock()
-		if err != nil && err.Error() == "not enough nodes" {
+		if err == lock.ErrNotEnoughNodes {
 			return
 		} else if err != nil {
 			log.Fatal(err)
@@ -89,11 +222,95 @@ func (c *Cluster) JoinCallback(event hserf.MemberEvent) {
 				continue
 			}
 
-			if err := c.raft.AddNode(member.Name, member.Addr, member.Port+1); err != nil {
+			if err := c.raft.AddNode(member.Name, MemberRaftAddr(member)); err != nil {
 				log.Fatal("error adding member", err)
 			}
 		}
 	}
+
+	c.notifyMembers()
+}
+
+// MemberCallback is called when a member leaves, fails or is updated.
+func (c *Cluster) MemberCallback(event hserf.MemberEvent) {
+	if event.EventType() == hserf.EventMemberLeave && c.raft.Leader() {
+		for _, member := range event.Members {
+			if member.Name == c.NodeName {
+				continue
+			}
+
+			if err := c.raft.RemoveNode(member.Name); err != nil {
+				log.Println("error removing member", err)
+			}
+		}
+	}
+
+	c.notifyMembers()
+}
+
+func (c *Cluster) notifyMembers() {
+	select {
+	case c.memberCh <- true:
+	default:
+	}
+}
+
+// MemberChannel receives a value whenever cluster membership changes.
+func (c *Cluster) MemberChannel() chan bool {
+	return c.memberCh
+}
+
+// QueryCallback passes the requests forwarded to this node on to
+// RequestChannel. It runs on serf's event loop, so requests are dropped rather
+// than blocking membership events if they are not being handled. The sender
+// times out waiting for a response.
+func (c *Cluster) QueryCallback(query *hserf.Query) {
+	select {
+	case c.requestCh <- query:
+	default:
+		log.Println("dropping", query.Name, "request, too many requests are pending")
+	}
+}
+
+// RequestChannel receives the requests other members send to this node, e.g.
+// while it is the raft leader. A request is answered by responding with an empty
+// payload on success, or the error message.
+func (c *Cluster) RequestChannel() chan *hserf.Query {
+	return c.requestCh
+}
+
+// ForwardToLeader sends a request to the raft leader and waits for its response,
+// for changes that only the leader can replicate.
+func (c *Cluster) ForwardToLeader(name string, payload []byte) error {
+	status, err := c.raft.Status()
+	if err != nil {
+		return err
+	}
+
+	if status.Leader == "" {
+		return errors.New("there is no raft leader")
+	}
+
+	return c.Request(status.Leader, name, payload, forwardTimeout)
+}
+
+// Request sends a request to a member and waits up to timeout for its response.
+func (c *Cluster) Request(node, name string, payload []byte, timeout time.Duration) error {
+	resp, err := c.serf.Query(name, payload, []string{node}, timeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	for r := range resp.ResponseCh() {
+		if len(r.Payload) > 0 {
+			return fmt.Errorf("%s: %s", r.From, r.Payload)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("no response from %s", node)
 }
 
 func (c *Cluster) Send(obj interface{}) error {
@@ -116,20 +333,138 @@ func (c *Cluster) Members() []hserf.Member {
 	return c.serf.Members()
 }
 
+// ActiveMembers returns the members that are alive and not leaving the cluster.
+func (c *Cluster) ActiveMembers() []hserf.Member {
+	members := []hserf.Member{}
+
+	for _, member := range c.serf.Members() {
+		if member.Status != hserf.StatusAlive || member.Tags[LeavingTag] != "" {
+			continue
+		}
+
+		members = append(members, member)
+	}
+
+	return members
+}
+
+// Leader returns true if this node is the raft leader.
+func (c *Cluster) Leader() bool {
+	return c.raft.Leader()
+}
+
+// RaftStatus returns the raft state, leader and peers of this node.
+func (c *Cluster) RaftStatus() (raft.Status, error) {
+	return c.raft.Status()
+}
+
+// LocalMember returns the serf member for this node.
+func (c *Cluster) LocalMember() hserf.Member {
+	return c.serf.LocalMember()
+}
+
+// SetTag sets a serf tag advertised by this node.
+func (c *Cluster) SetTag(key, value string) error {
+	tags := map[string]string{}
+	for k, v := range c.serf.LocalMember().Tags {
+		tags[k] = v
+	}
+
+	if tags[key] == value {
+		return nil
+	}
+
+	tags[key] = value
+
+	if key == RoleTag && c.mdns != nil {
+		if err := c.mdns.SetTXT(discovery.TXTRole, value); err != nil {
+			log.Println("error updating mDNS role", err)
+		}
+	}
+
+	return c.serf.SetTags(tags)
+}
+
+// ForceLeave removes a failed member from the cluster immediately. The leader
+// removes it from raft once it has left serf.
+func (c *Cluster) ForceLeave(name string) error {
+	return c.serf.RemoveFailedNode(name)
+}
+
+// Keyring lists the gossip encryption keys of every member, or installs, uses or
+// removes a key on every member.
+func (c *Cluster) Keyring(operation, key string) (*hserf.KeyResponse, error) {
+	manager := c.serf.KeyManager()
+
+	switch operation {
+	case KeyringList:
+		return manager.ListKeys()
+	case KeyringInstall:
+		return manager.InstallKey(key)
+	case KeyringUse:
+		return manager.UseKey(key)
+	case KeyringRemove:
+		return manager.RemoveKey(key)
+	}
+
+	return nil, fmt.Errorf("invalid keyring operation %s, must be %s, %s, %s or %s", operation, KeyringList, KeyringInstall, KeyringUse, KeyringRemove)
+}
+
+// SetLeaving marks this node as leaving so that it is no longer picked for any
+// role.
+func (c *Cluster) SetLeaving() error {
+	return c.SetTag(LeavingTag, "true")
+}
+
+// Leave removes this node from raft and gracefully leaves serf.
+func (c *Cluster) Leave() error {
+	if c.raft.Leader() {
+		if err := c.raft.RemoveNode(c.NodeName); err != nil {
+			log.Println("error removing self from raft", err)
+		}
+	}
+
+	if err := c.raft.Shutdown(); err != nil {
+		log.Println("error shutting down raft", err)
+	}
+
+	if c.mdns != nil {
+		if err := c.mdns.Shutdown(); err != nil {
+			log.Println("error shutting down mDNS", err)
+		}
+	}
+
+	return c.serf.Leave()
+}
+
 func (c *Cluster) Announce() error {
 	if c.MDNSService == "" {
 		return nil
 	}
 
-	service, err := mdns.NewMDNSService(c.NodeName, c.MDNSService, "", "", c.Port, nil, []string{})
-	if err != nil {
-		return err
+	var iface *net.Interface
+	if c.Interface != "" {
+		var err error
+		iface, err = net.InterfaceByName(c.Interface)
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err = mdns.NewServer(&mdns.Config{Zone: service})
+	txt := map[string]string{
+		discovery.TXTClusterID: c.ClusterID,
+		discovery.TXTSerfPort:  strconv.Itoa(c.AdvertisePort),
+		discovery.TXTVersion:   version.Version,
+		discovery.TXTRole:      c.serf.LocalMember().Tags[RoleTag],
+	}
+
+	var err error
+	c.mdns, err = discovery.NewMDNSAnnouncer(c.NodeName, c.MDNSService, net.ParseIP(c.Addr), c.AdvertisePort, iface, txt)
 	return err
 }
 
+// DiscoverNodes runs discovery whenever fewer members than expected are alive,
+// backing off while discovery finds no new peers.
 func (c *Cluster) DiscoverNodes() {
 	logger := ioutil.Discard
 	if os.Getenv("DEBUG") == "1" {
@@ -138,39 +473,147 @@ func (c *Cluster) DiscoverNodes() {
 
 	l := log.New(logger, "", log.LstdFlags)
 
-	d := discover.Discover{}
+	d := discovery.NewDiscover()
+
+	discoveryConfig := []string{}
+	for _, cfg := range c.DiscoveryConfig {
+		discoveryConfig = append(discoveryConfig, c.withClusterID(cfg))
+	}
 
-	discoveryConfig := c.DiscoveryConfig
 	if c.MDNSService != "" {
-		discoveryConfig = append(discoveryConfig, fmt.Sprintf("provider=mdns service=%s domain=local", c.MDNSService))
+		mdnsConfig := discover.Config{
+			"provider":   "mdns",
+			"service":    c.MDNSService,
+			"domain":     "local",
+			"cluster_id": c.ClusterID,
+			"interface":  c.Interface,
+		}
+		discoveryConfig = append(discoveryConfig, mdnsConfig.String())
 	}
 
+	if c.beacon != nil {
+		d.Providers["beacon"] = c.beacon
+		discoveryConfig = append(discoveryConfig, "provider=beacon")
+	}
+
+	go c.watchPeersFiles(d, discoveryConfig, l)
+
 	seenPeers := map[string]bool{}
+	interval := minDiscoveryInterval
 
 	for {
+		if !c.serf.NeedsPeers() {
+			interval = minDiscoveryInterval
+			time.Sleep(minDiscoveryInterval)
+			continue
+		}
+
+		found := 0
+
 		for _, cfg := range discoveryConfig {
 			tmpAddrs, err := d.Addrs(cfg, l)
+			c.discoveryMetrics.observe(providerName(cfg), tmpAddrs, err)
 			if err != nil {
 				log.Println(err)
 				continue
 			}
 
 			for _, addr := range tmpAddrs {
-				if seenPeers[addr] {
-					continue
+				addr = util.EnsurePort(addr, c.AdvertisePort)
+
+				if !seenPeers[addr] {
+					seenPeers[addr] = true
+					found++
+					log.Println("Discovered peer:", addr)
 				}
 
-				seenPeers[addr] = true
+				c.serf.AddNode(addr)
+			}
+		}
+
+		if found > 0 {
+			interval = minDiscoveryInterval
+		} else {
+			interval *= 2
+			if interval > maxDiscoveryInterval {
+				interval = maxDiscoveryInterval
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// watchPeersFiles joins the peers added to the files of file discovery
+// configurations. Discovery stops once enough members are alive, so without it
+// peers added to a file later would never be joined.
+func (c *Cluster) watchPeersFiles(d *discover.Discover, discoveryConfig []string, l *log.Logger) {
+	known := map[string]map[string]bool{}
+
+	for _, cfg := range discoveryConfig {
+		if providerName(cfg) == "file" {
+			known[cfg] = nil
+		}
+	}
+
+	if len(known) == 0 {
+		return
+	}
 
-				if ! strings.Contains(addr, ":") {
-					addr = fmt.Sprintf("%s:%d", addr, c.Port)
+	ticker := time.NewTicker(peersFileInterval)
+	defer ticker.Stop()
+
+	for {
+		for cfg, addrs := range known {
+			// The file provider only re-reads the file when its mtime changes.
+			tmpAddrs, err := d.Addrs(cfg, l)
+			if err != nil {
+				continue
+			}
+
+			current := map[string]bool{}
+			added := []string{}
+
+			for _, addr := range tmpAddrs {
+				addr = util.EnsurePort(addr, c.AdvertisePort)
+				current[addr] = true
+
+				if addrs != nil && !addrs[addr] {
+					added = append(added, addr)
 				}
+			}
 
-				log.Println("Discovered peer:", addr)
-				c.serf.AddNode(addr)
+			// The peers listed when civitas starts are joined by discovery.
+			known[cfg] = current
+
+			if len(added) > 0 {
+				log.Println("Joining peers added to peers file:", added)
+				c.serf.JoinNodes(added)
 			}
 		}
 
-		time.Sleep(2 * time.Second)
+		<-ticker.C
+	}
+}
+
+// withClusterID sets the cluster ID of an ipfs discovery configuration that does
+// not set its own.
+func (c *Cluster) withClusterID(cfg string) string {
+	args, err := discover.Parse(cfg)
+	if err != nil || args["provider"] != "ipfs" || args["cluster_id"] != "" {
+		return cfg
 	}
+
+	args["cluster_id"] = c.ClusterID
+	return args.String()
+}
+
+// providerName returns the name of the provider in a discovery configuration.
+func providerName(cfg string) string {
+	args, err := discover.Parse(cfg)
+	if err != nil || args["provider"] == "" {
+		return "unknown"
+	}
+
+	return args["provider"]
 }
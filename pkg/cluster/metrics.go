@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	hserf "github.com/hashicorp/serf/serf"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+)
+
+// The raft states exported by civitas_raft_state.
+var raftStates = []string{"Follower", "Candidate", "Leader", "Shutdown"}
+
+// The raft statistics exported as gauges.
+var raftStats = map[string]string{
+	"term":           "The current raft term.",
+	"commit_index":   "The index of the last committed raft log entry.",
+	"applied_index":  "The index of the last raft log entry applied to the cluster state.",
+	"last_log_index": "The index of the last raft log entry stored on this node.",
+	"num_peers":      "The number of other raft peers.",
+}
+
+// collector exports the serf members and raft state as Prometheus metrics.
+type collector struct {
+	cluster   *Cluster
+	members   *prometheus.Desc
+	raftState *prometheus.Desc
+	raftStats map[string]*prometheus.Desc
+}
+
+func newCollector(c *Cluster) *collector {
+	col := &collector{
+		cluster: c,
+		members: prometheus.NewDesc(
+			"civitas_serf_members",
+			"Number of serf members by status.",
+			[]string{"status"}, nil,
+		),
+		raftState: prometheus.NewDesc(
+			"civitas_raft_state",
+			"Whether this node is in the raft state.",
+			[]string{"state"}, nil,
+		),
+		raftStats: map[string]*prometheus.Desc{},
+	}
+
+	for stat, help := range raftStats {
+		col.raftStats[stat] = prometheus.NewDesc("civitas_raft_"+stat, help, nil, nil)
+	}
+
+	return col
+}
+
+func (col *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- col.members
+	ch <- col.raftState
+	for _, desc := range col.raftStats {
+		ch <- desc
+	}
+}
+
+func (col *collector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[string]int{}
+	for _, status := range []hserf.MemberStatus{hserf.StatusAlive, hserf.StatusLeaving, hserf.StatusLeft, hserf.StatusFailed} {
+		counts[status.String()] = 0
+	}
+
+	for _, member := range col.cluster.Members() {
+		counts[member.Status.String()]++
+	}
+
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(col.members, prometheus.GaugeValue, float64(count), status)
+	}
+
+	stats := col.cluster.raft.Stats()
+
+	for _, state := range raftStates {
+		value := 0.0
+		if stats["state"] == state {
+			value = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(col.raftState, prometheus.GaugeValue, value, state)
+	}
+
+	for stat, desc := range col.raftStats {
+		value, err := strconv.ParseFloat(stats[stat], 64)
+		if err != nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+}
+
+// discoveryMetrics count the results of each discovery provider.
+type discoveryMetrics struct {
+	runs  *prometheus.CounterVec
+	addrs *prometheus.GaugeVec
+}
+
+func newDiscoveryMetrics() *discoveryMetrics {
+	return &discoveryMetrics{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "civitas_discovery_runs_total",
+			Help: "Number of times each discovery provider was queried, by result.",
+		}, []string{"provider", "result"}),
+		addrs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "civitas_discovery_addresses",
+			Help: "Number of addresses returned by the last successful query of each discovery provider.",
+		}, []string{"provider"}),
+	}
+}
+
+// observe records the result of querying a provider.
+func (m *discoveryMetrics) observe(provider string, addrs []string, err error) {
+	if err != nil {
+		m.runs.WithLabelValues(provider, "error").Inc()
+		return
+	}
+
+	m.runs.WithLabelValues(provider, "success").Inc()
+	m.addrs.WithLabelValues(provider).Set(float64(len(addrs)))
+}
+
+// Collectors returns the Prometheus collectors for the cluster's serf, raft,
+// discovery and bootstrap lock metrics.
+func (c *Cluster) Collectors() []prometheus.Collector {
+	collectors := []prometheus.Collector{
+		newCollector(c),
+		c.discoveryMetrics.runs,
+		c.discoveryMetrics.addrs,
+	}
+
+	return append(collectors, c.lock.Collectors()...)
+}
@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	hserf "github.com/hashicorp/serf/serf"
+	"log"
+	"net"
+	"strconv"
+)
+
+const (
+	// RaftPortTag is the serf tag advertising a node's Raft port.
+	RaftPortTag = "raft_port"
+	// LockPortTag is the serf tag advertising a node's bootstrap lock port.
+	LockPortTag = "lock_port"
+	// PrivateAddrTag is the serf tag advertising a node's private address when it
+	// advertises a public address.
+	PrivateAddrTag = "private_addr"
+)
+
+// setDefaults fills in unset addresses and ports. Unless configured otherwise,
+// every protocol binds to the advertised address, the Raft and lock ports follow
+// the serf port, and each port is advertised as it is bound.
+func (c *Cluster) setDefaults() {
+	if c.BindAddr == "" {
+		c.BindAddr = c.Addr
+	}
+
+	if bindIP := net.ParseIP(c.BindAddr); c.PrivateAddr == "" && c.BindAddr != c.Addr && bindIP != nil && !bindIP.IsUnspecified() {
+		c.PrivateAddr = c.BindAddr
+	}
+
+	if c.RaftPort == 0 {
+		c.RaftPort = c.Port + 1
+	}
+
+	if c.LockPort == 0 {
+		c.LockPort = c.Port + 2
+	}
+
+	if c.AdvertisePort == 0 {
+		c.AdvertisePort = c.Port
+	}
+
+	if c.RaftAdvertisePort == 0 {
+		c.RaftAdvertisePort = c.RaftPort
+	}
+
+	if c.LockAdvertisePort == 0 {
+		c.LockAdvertisePort = c.LockPort
+	}
+}
+
+// bindAddr returns the address a protocol listens on.
+func (c *Cluster) bindAddr(port int) string {
+	return net.JoinHostPort(c.BindAddr, strconv.Itoa(port))
+}
+
+// advertiseAddr returns the address peers use to reach a protocol.
+func (c *Cluster) advertiseAddr(port int) string {
+	return net.JoinHostPort(c.Addr, strconv.Itoa(port))
+}
+
+// portTags returns the serf tags advertising this node's Raft and lock ports.
+func (c *Cluster) portTags() map[string]string {
+	tags := map[string]string{
+		RaftPortTag: strconv.Itoa(c.RaftAdvertisePort),
+		LockPortTag: strconv.Itoa(c.LockAdvertisePort),
+	}
+
+	if c.PrivateAddr != "" && c.PrivateAddr != c.Addr {
+		tags[PrivateAddrTag] = c.PrivateAddr
+	}
+
+	return tags
+}
+
+// LocalAddr returns the address of this node on its own network, which is the
+// private address if it advertises a public address.
+func (c *Cluster) LocalAddr() string {
+	if c.PrivateAddr != "" {
+		return c.PrivateAddr
+	}
+
+	return c.Addr
+}
+
+// MemberIP returns the address to connect to a member on: its private address if
+// it is on the same network segment as this node, otherwise its advertised
+// address. It is only used for the bootstrap lock and the load balancer: serf
+// gossips with and raft dials advertised addresses.
+func MemberIP(member hserf.Member) net.IP {
+	private := net.ParseIP(member.Tags[PrivateAddrTag])
+	if private == nil {
+		return member.Addr
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return member.Addr
+	}
+
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && !network.IP.IsLoopback() && network.Contains(private) {
+			return private
+		}
+	}
+
+	return member.Addr
+}
+
+// ReachableMembers returns the members with their address set to the address
+// this node should connect to them on.
+func (c *Cluster) ReachableMembers() []hserf.Member {
+	members := c.serf.Members()
+
+	for i := range members {
+		members[i].Addr = MemberIP(members[i])
+	}
+
+	return members
+}
+
+// memberAddr returns the address of a member's protocol on ip from the port in
+// its tags. Members that do not advertise the port are assumed to use the serf
+// port plus offset.
+func memberAddr(member hserf.Member, ip net.IP, tag string, offset int) string {
+	port := int(member.Port) + offset
+
+	if value, ok := member.Tags[tag]; ok {
+		tagPort, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("invalid %s tag %q on member %s\n", tag, value, member.Name)
+		} else {
+			port = tagPort
+		}
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// MemberRaftAddr returns the advertised address of a member's Raft server. It is
+// stored in the raft configuration shared by every peer, so the private address
+// is never used: it is only reachable from the member's own network.
+func MemberRaftAddr(member hserf.Member) string {
+	return memberAddr(member, member.Addr, RaftPortTag, 1)
+}
+
+// MemberLockAddr returns the address this node connects to a member's bootstrap
+// lock server on.
+func MemberLockAddr(member hserf.Member) string {
+	return memberAddr(member, MemberIP(member), LockPortTag, 2)
+}
@@ -0,0 +1,58 @@
+package discovery
+
+import (
+	"github.com/hashicorp/go-discover"
+	"github.com/justinbarrick/civitas/pkg/ipfs"
+	"log"
+	"strings"
+)
+
+// Provider discovers the addresses of peers. It has the same methods as a
+// go-discover provider, so civitas and go-discover providers are interchangeable.
+//
+// Addresses may include a port, otherwise the Serf port is used.
+type Provider interface {
+	// Addrs returns the addresses of peers for the configuration in args.
+	Addrs(args map[string]string, l *log.Logger) ([]string, error)
+	// Help describes the configuration options of the provider.
+	Help() string
+}
+
+// Providers contains the civitas providers and the go-discover providers, keyed
+// by the provider name used in a discovery configuration.
+var Providers = map[string]Provider{
+	"static": &StaticProvider{},
+	"file":   &FileProvider{},
+	"dns":    &DNSProvider{},
+	"http":   &HTTPProvider{},
+	"mdns":   &MDNSProvider{},
+	"ipfs":   &ipfs.Provider{},
+}
+
+func init() {
+	for name, provider := range discover.Providers {
+		if _, ok := Providers[name]; !ok {
+			Providers[name] = provider
+		}
+	}
+}
+
+// NewDiscover returns a go-discover Discover that looks up addresses using
+// Providers. Configuration strings have the form "provider=xxx key=val ...".
+func NewDiscover() *discover.Discover {
+	providers := map[string]discover.Provider{}
+	for name, provider := range Providers {
+		providers[name] = provider
+	}
+
+	return &discover.Discover{
+		Providers: providers,
+	}
+}
+
+// splitAddrs splits a list of addresses separated by commas or whitespace.
+func splitAddrs(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
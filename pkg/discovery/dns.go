@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+)
+
+// DNSProvider looks up peers from DNS A or SRV records.
+type DNSProvider struct{}
+
+func (p *DNSProvider) Help() string {
+	return `DNS:
+
+    provider: "dns"
+    name:     The DNS name to look up, e.g. peers.example.com or _civitas._udp.example.com
+    type:     The record type, "a" (default) or "srv"
+    port:     The port to use for A records, defaults to the Serf port
+`
+}
+
+func (p *DNSProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	name := args["name"]
+	if name == "" {
+		return nil, fmt.Errorf("discover-dns: name is required")
+	}
+
+	switch args["type"] {
+	case "", "a":
+		return p.lookupA(name, args["port"], l)
+	case "srv":
+		return p.lookupSRV(name, l)
+	default:
+		return nil, fmt.Errorf("discover-dns: invalid record type %s", args["type"])
+	}
+}
+
+func (p *DNSProvider) lookupA(name, port string, l *log.Logger) ([]string, error) {
+	if port != "" {
+		if _, err := strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("discover-dns: invalid port %s", port)
+		}
+	}
+
+	ips, err := net.LookupIP(name)
+	if err != nil {
+		return nil, fmt.Errorf("discover-dns: %s", err)
+	}
+
+	addrs := []string{}
+	for _, ip := range ips {
+		if port != "" {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+		} else {
+			addrs = append(addrs, ip.String())
+		}
+	}
+
+	l.Printf("[DEBUG] discover-dns: %s resolved to %v", name, addrs)
+	return addrs, nil
+}
+
+func (p *DNSProvider) lookupSRV(name string, l *log.Logger) ([]string, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("discover-dns: %s", err)
+	}
+
+	addrs := []string{}
+	for _, record := range records {
+		ips, err := net.LookupIP(record.Target)
+		if err != nil {
+			l.Printf("[WARN] discover-dns: could not resolve %s: %s", record.Target, err)
+			continue
+		}
+
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(int(record.Port))))
+		}
+	}
+
+	l.Printf("[DEBUG] discover-dns: %s resolved to %v", name, addrs)
+	return addrs, nil
+}
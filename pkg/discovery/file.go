@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileProvider reads peers from a file with one address per line. The file is
+// only re-read when its mtime changes. The cluster keeps looking up the file's
+// addresses after discovery stops and joins the peers added to it, so peers can
+// be added without restarting civitas.
+type FileProvider struct {
+	mutex sync.Mutex
+	files map[string]*peersFile
+}
+
+type peersFile struct {
+	modTime time.Time
+	addrs   []string
+}
+
+func (p *FileProvider) Help() string {
+	return `File:
+
+    provider: "file"
+    path:     Path to a file listing one peer address per line, lines starting with # are ignored
+`
+}
+
+func (p *FileProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	path := args["path"]
+	if path == "" {
+		return nil, fmt.Errorf("discover-file: path is required")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("discover-file: %s", err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.files == nil {
+		p.files = map[string]*peersFile{}
+	}
+
+	cached := p.files[path]
+	if cached != nil && cached.modTime.Equal(info.ModTime()) {
+		return cached.addrs, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("discover-file: %s", err)
+	}
+
+	addrs := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		addrs = append(addrs, splitAddrs(line)...)
+	}
+
+	if cached != nil {
+		log.Printf("peers file %s changed, %d peers listed\n", path, len(addrs))
+	}
+
+	p.files[path] = &peersFile{
+		modTime: info.ModTime(),
+		addrs:   addrs,
+	}
+
+	return addrs, nil
+}
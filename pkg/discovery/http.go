@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// HTTPProvider fetches peers from an HTTP endpoint returning a JSON list of
+// addresses, either as a list or as an object with a peers list:
+//
+//	["10.0.0.1", "10.0.0.2:1234"]
+//	{"peers": ["10.0.0.1", "10.0.0.2:1234"]}
+type HTTPProvider struct{}
+
+func (p *HTTPProvider) Help() string {
+	return `HTTP:
+
+    provider: "http"
+    url:      URL returning a JSON list of peer addresses, or an object with a "peers" list
+`
+}
+
+func (p *HTTPProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	url := args["url"]
+	if url == "" {
+		return nil, fmt.Errorf("discover-http: url is required")
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("discover-http: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discover-http: %s returned %s", url, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("discover-http: %s", err)
+	}
+
+	addrs := []string{}
+	if err := json.Unmarshal(data, &addrs); err == nil {
+		return addrs, nil
+	}
+
+	peers := struct {
+		Peers []string `json:"peers"`
+	}{}
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("discover-http: invalid response from %s: %s", url, err)
+	}
+
+	return peers.Peers, nil
+}
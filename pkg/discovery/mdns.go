@@ -0,0 +1,227 @@
+package discovery
+
+import (
+	"fmt"
+	"github.com/hashicorp/mdns"
+	"github.com/miekg/dns"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TXT record keys announced over mDNS.
+const (
+	TXTClusterID = "cluster"
+	TXTSerfPort  = "serf_port"
+	TXTVersion   = "version"
+	TXTRole      = "role"
+)
+
+// MDNSProvider looks up peers announced over mDNS by an MDNSAnnouncer. Unlike the
+// go-discover mdns provider it uses the announced serf port and can ignore peers
+// that belong to another cluster.
+type MDNSProvider struct{}
+
+func (p *MDNSProvider) Help() string {
+	return `mDNS:
+
+    provider:   "mdns"
+    service:    The mDNS service name
+    domain:     The mDNS discovery domain, defaults to "local"
+    cluster_id: Only return peers announcing this cluster ID
+    interface:  The interface to query on, defaults to the system default
+    timeout:    How long to wait for responses, defaults to 5s
+`
+}
+
+func (p *MDNSProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	if args["service"] == "" {
+		return nil, fmt.Errorf("discover-mdns: service is required")
+	}
+
+	params := mdns.DefaultParams(args["service"])
+	params.Timeout = 5 * time.Second
+
+	if args["domain"] != "" {
+		params.Domain = args["domain"]
+	}
+
+	if args["timeout"] != "" {
+		timeout, err := time.ParseDuration(args["timeout"])
+		if err != nil {
+			return nil, fmt.Errorf("discover-mdns: invalid timeout: %s", err)
+		}
+		params.Timeout = timeout
+	}
+
+	if args["interface"] != "" {
+		iface, err := net.InterfaceByName(args["interface"])
+		if err != nil {
+			return nil, fmt.Errorf("discover-mdns: %s", err)
+		}
+		params.Interface = iface
+	}
+
+	entries := make(chan *mdns.ServiceEntry)
+	params.Entries = entries
+
+	addrs := []string{}
+	done := make(chan bool)
+
+	go func() {
+		for entry := range entries {
+			if addr := p.entryAddr(entry, args["cluster_id"], l); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+		done <- true
+	}()
+
+	err := mdns.Query(params)
+
+	close(entries)
+	<-done
+
+	if err != nil {
+		return nil, fmt.Errorf("discover-mdns: %s", err)
+	}
+
+	return addrs, nil
+}
+
+// entryAddr returns the serf address of a service entry, or an empty string if
+// it is not a peer in the cluster.
+func (p *MDNSProvider) entryAddr(entry *mdns.ServiceEntry, clusterID string, l *log.Logger) string {
+	txt := parseTXT(entry.InfoFields)
+
+	if clusterID != "" && txt[TXTClusterID] != clusterID {
+		l.Printf("[DEBUG] discover-mdns: ignoring %s from cluster %q", entry.Name, txt[TXTClusterID])
+		return ""
+	}
+
+	ip := entry.AddrV4
+	if ip == nil {
+		ip = entry.AddrV6
+	}
+
+	if ip == nil {
+		return ""
+	}
+
+	port := strconv.Itoa(entry.Port)
+	if txt[TXTSerfPort] != "" {
+		port = txt[TXTSerfPort]
+	}
+
+	l.Printf("[DEBUG] discover-mdns: found %s version %s role %s", entry.Name, txt[TXTVersion], txt[TXTRole])
+	return net.JoinHostPort(ip.String(), port)
+}
+
+// parseTXT parses key=value TXT record fields.
+func parseTXT(fields []string) map[string]string {
+	txt := map[string]string{}
+
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) == 2 {
+			txt[parts[0]] = parts[1]
+		}
+	}
+
+	return txt
+}
+
+// MDNSAnnouncer announces this node as an mDNS service with its cluster metadata
+// in TXT records. The TXT records can be updated while the node is running.
+type MDNSAnnouncer struct {
+	instance string
+	service  string
+	ip       net.IP
+	port     int
+	txt      map[string]string
+	zone     *mdns.MDNSService
+	server   *mdns.Server
+	mutex    sync.Mutex
+}
+
+// NewMDNSAnnouncer starts announcing the service, answering queries only on
+// iface if it is not nil.
+func NewMDNSAnnouncer(instance, service string, ip net.IP, port int, iface *net.Interface, txt map[string]string) (*MDNSAnnouncer, error) {
+	a := &MDNSAnnouncer{
+		instance: instance,
+		service:  service,
+		ip:       ip,
+		port:     port,
+		txt:      map[string]string{},
+	}
+
+	for key, value := range txt {
+		a.txt[key] = value
+	}
+
+	if err := a.updateZone(); err != nil {
+		return nil, err
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{
+		Zone:  a,
+		Iface: iface,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.server = server
+	return a, nil
+}
+
+// updateZone rebuilds the service records from the current TXT fields, it must be
+// called with the mutex held.
+func (a *MDNSAnnouncer) updateZone() error {
+	fields := []string{}
+	for key, value := range a.txt {
+		if value != "" {
+			fields = append(fields, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	sort.Strings(fields)
+
+	zone, err := mdns.NewMDNSService(a.instance, a.service, "", "", a.port, []net.IP{a.ip}, fields)
+	if err != nil {
+		return err
+	}
+
+	a.zone = zone
+	return nil
+}
+
+// Records answers mDNS queries using the current service records.
+func (a *MDNSAnnouncer) Records(q dns.Question) []dns.RR {
+	a.mutex.Lock()
+	zone := a.zone
+	a.mutex.Unlock()
+
+	return zone.Records(q)
+}
+
+// SetTXT updates a TXT record field.
+func (a *MDNSAnnouncer) SetTXT(key, value string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.txt[key] == value {
+		return nil
+	}
+
+	a.txt[key] = value
+	return a.updateZone()
+}
+
+// Shutdown stops announcing the service.
+func (a *MDNSAnnouncer) Shutdown() error {
+	return a.server.Shutdown()
+}
@@ -0,0 +1,26 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+)
+
+// StaticProvider returns a fixed list of peers.
+type StaticProvider struct{}
+
+func (p *StaticProvider) Help() string {
+	return `Static:
+
+    provider: "static"
+    addrs:    Comma or space separated list of peer addresses, e.g. "10.0.0.1,10.0.0.2:1234"
+`
+}
+
+func (p *StaticProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	addrs := splitAddrs(args["addrs"])
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("discover-static: addrs is required")
+	}
+
+	return addrs, nil
+}
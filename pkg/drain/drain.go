@@ -0,0 +1,226 @@
+package drain
+
+import (
+	"errors"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultKubeconfigs are the kubeconfigs tried, in order, to talk to the
+// Kubernetes API.
+var DefaultKubeconfigs = []string{
+	"/etc/kubernetes/admin.conf",
+	"/etc/kubernetes/kubelet.conf",
+}
+
+// ErrNotAuthorized is returned by Decommission if the kubeconfig it found is not
+// allowed to drain and delete nodes, e.g. the kubelet's credentials on a worker.
+var ErrNotAuthorized = errors.New("the kubeconfig is not authorized to evict pods and delete nodes")
+
+// Drainer cordons and drains Kubernetes nodes and removes them from the cluster.
+type Drainer struct {
+	kubeconfigs []string
+	timeout     time.Duration
+	interval    time.Duration
+}
+
+func NewDrainer(kubeconfigs []string, timeout time.Duration) *Drainer {
+	return &Drainer{
+		kubeconfigs: kubeconfigs,
+		timeout:     timeout,
+		interval:    5 * time.Second,
+	}
+}
+
+// client returns a client using the first kubeconfig that exists, or nil if this
+// node has no kubeconfig because it has not joined a cluster.
+func (d *Drainer) client() (kubernetes.Interface, error) {
+	for _, kubeconfig := range d.kubeconfigs {
+		if _, err := os.Stat(kubeconfig); err != nil {
+			continue
+		}
+
+		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Timeout = 30 * time.Second
+		return kubernetes.NewForConfig(config)
+	}
+
+	return nil, nil
+}
+
+// Timeout returns how long evictions are retried for when draining a node.
+func (d *Drainer) Timeout() time.Duration {
+	return d.timeout
+}
+
+// authorized returns true if the client may evict pods in every namespace and
+// delete nodes.
+func (d *Drainer) authorized(client kubernetes.Interface) (bool, error) {
+	checks := []authorizationv1.ResourceAttributes{
+		{Verb: "create", Resource: "pods", Subresource: "eviction"},
+		{Verb: "delete", Resource: "nodes"},
+	}
+
+	for i := range checks {
+		review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(&authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &checks[i],
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+
+		if !review.Status.Allowed {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Cordon marks the node unschedulable.
+func (d *Drainer) Cordon(client kubernetes.Interface, nodeName string) error {
+	node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if node.Spec.Unschedulable {
+		return nil
+	}
+
+	log.Println("cordoning node:", nodeName)
+
+	node.Spec.Unschedulable = true
+	_, err = client.CoreV1().Nodes().Update(node)
+	return err
+}
+
+// evictable returns true if the pod should be evicted when draining.
+func evictable(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+
+	if _, mirror := pod.Annotations[corev1.MirrorPodAnnotationKey]; mirror {
+		return false
+	}
+
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Evict evicts all pods from the node, retrying evictions blocked by a
+// PodDisruptionBudget until the drain timeout is reached.
+func (d *Drainer) Evict(client kubernetes.Interface, nodeName string) error {
+	deadline := time.Now().Add(d.timeout)
+
+	for {
+		pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+			FieldSelector: fmt.Sprintf("spec.nodeName=%s", nodeName),
+		})
+		if err != nil {
+			return err
+		}
+
+		remaining := 0
+
+		for _, pod := range pods.Items {
+			if !evictable(pod) {
+				continue
+			}
+
+			remaining++
+
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+
+			err := client.PolicyV1beta1().Evictions(pod.Namespace).Evict(&policyv1beta1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      pod.Name,
+					Namespace: pod.Namespace,
+				},
+			})
+			if apierrors.IsTooManyRequests(err) {
+				log.Printf("eviction of %s/%s blocked by disruption budget, retrying\n", pod.Namespace, pod.Name)
+			} else if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+
+		if remaining == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out draining node %s, %d pods remaining", nodeName, remaining)
+		}
+
+		time.Sleep(d.interval)
+	}
+}
+
+// Delete removes the node object from Kubernetes.
+func (d *Drainer) Delete(client kubernetes.Interface, nodeName string) error {
+	log.Println("deleting node:", nodeName)
+
+	err := client.CoreV1().Nodes().Delete(nodeName, &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Decommission cordons and drains the node and then deletes it from Kubernetes.
+// If this node has not joined a Kubernetes cluster or the node is not registered,
+// it does nothing. ErrNotAuthorized is returned if the kubeconfig found cannot
+// drain nodes.
+func (d *Drainer) Decommission(nodeName string) error {
+	client, err := d.client()
+	if err != nil {
+		return err
+	} else if client == nil {
+		return nil
+	}
+
+	authorized, err := d.authorized(client)
+	if err != nil {
+		return fmt.Errorf("could not check drain permissions: %s", err)
+	} else if !authorized {
+		return ErrNotAuthorized
+	}
+
+	if err := d.Cordon(client, nodeName); apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Println("draining node:", nodeName)
+
+	if err := d.Evict(client, nodeName); err != nil {
+		return err
+	}
+
+	return d.Delete(client, nodeName)
+}
@@ -0,0 +1,243 @@
+package echo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// A STUN-like service that tells a node the address its packets arrive from, so
+// that nodes behind NAT can discover their public address. Requests and responses
+// are single UDP packets.
+//
+// A request is the request magic, a random nonce, the time it was sent and, if a
+// key is set, an HMAC-SHA256 of them, padded to requestSize. A response is the
+// response magic, the request's nonce, the address and, if a key is set, an
+// HMAC-SHA256 of them. Requests are padded so that responses are never larger,
+// and each source is answered at most once per rateInterval, so the service
+// cannot be used to amplify or flood traffic to a spoofed address.
+
+var (
+	requestMagic  = []byte("civitas-echo?")
+	responseMagic = []byte("civitas-echo=")
+)
+
+const (
+	attempts = 3
+	// The size requests are padded to, larger than any response.
+	requestSize = 128
+	nonceSize   = 16
+	// Requests sent longer ago or further in the future are not answered.
+	maxClockSkew = 2 * time.Minute
+	// How often each source address is answered.
+	rateInterval = time.Second
+	// The number of sources whose last response is remembered, the sources are
+	// forgotten once it is reached.
+	maxSources = 4096
+)
+
+// sign returns the HMAC of data with key, or nothing if there is no key.
+func sign(key, data []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// verify checks and strips the HMAC at the end of data.
+func verify(key, data []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return data, nil
+	}
+
+	if len(data) < sha256.Size {
+		return nil, errors.New("packet too short")
+	}
+
+	payload, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(mac, sign(key, payload)) {
+		return nil, errors.New("invalid signature")
+	}
+
+	return payload, nil
+}
+
+func newRequest(key, nonce []byte, now time.Time) []byte {
+	request := append(append([]byte{}, requestMagic...), nonce...)
+	request = append(request, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(request[len(request)-8:], uint64(now.Unix()))
+	request = append(request, sign(key, request)...)
+
+	return append(request, make([]byte, requestSize-len(request))...)
+}
+
+// parseRequest returns the nonce of a valid request.
+func parseRequest(key, request []byte, now time.Time) ([]byte, error) {
+	if len(request) != requestSize || !bytes.HasPrefix(request, requestMagic) {
+		return nil, errors.New("invalid request")
+	}
+
+	end := len(requestMagic) + nonceSize + 8
+	if len(key) > 0 {
+		end += sha256.Size
+	}
+
+	payload, err := verify(key, request[:end])
+	if err != nil {
+		return nil, err
+	}
+
+	sent := time.Unix(int64(binary.BigEndian.Uint64(payload[len(payload)-8:])), 0)
+	if skew := now.Sub(sent); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, fmt.Errorf("request time is %s off", skew)
+	}
+
+	return payload[len(requestMagic) : len(requestMagic)+nonceSize], nil
+}
+
+func newResponse(key, nonce []byte, ip net.IP) []byte {
+	response := append(append([]byte{}, responseMagic...), nonce...)
+	response = append(response, []byte(ip.String())...)
+	return append(response, sign(key, response)...)
+}
+
+// parseResponse returns the address in a valid response to the request with
+// nonce.
+func parseResponse(key, nonce, response []byte) (net.IP, error) {
+	payload, err := verify(key, response)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := append(append([]byte{}, responseMagic...), nonce...)
+	if !bytes.HasPrefix(payload, prefix) {
+		return nil, errors.New("invalid echo response")
+	}
+
+	ip := net.ParseIP(string(payload[len(prefix):]))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address in echo response: %q", payload[len(prefix):])
+	}
+
+	return ip, nil
+}
+
+// limiter allows one response per source address every rateInterval.
+type limiter struct {
+	mutex sync.Mutex
+	last  map[string]time.Time
+}
+
+func (l *limiter) allow(ip net.IP, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.last == nil || len(l.last) >= maxSources {
+		l.last = map[string]time.Time{}
+	}
+
+	if now.Sub(l.last[ip.String()]) < rateInterval {
+		return false
+	}
+
+	l.last[ip.String()] = now
+	return true
+}
+
+// Serve answers echo requests on addr until the listener fails. If key is set,
+// only requests signed with it are answered.
+func Serve(addr string, key []byte) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("echo listening at:", conn.LocalAddr())
+
+	go func() {
+		defer conn.Close()
+
+		buf := make([]byte, requestSize+1)
+		limit := &limiter{}
+
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				log.Println("error reading echo request:", err)
+				return
+			}
+
+			udpAddr, ok := from.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+
+			now := time.Now()
+
+			nonce, err := parseRequest(key, buf[:n], now)
+			if err != nil || !limit.allow(udpAddr.IP, now) {
+				continue
+			}
+
+			if _, err := conn.WriteTo(newResponse(key, nonce, udpAddr.IP), from); err != nil {
+				log.Println("error sending echo response:", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Query asks the echo service at server for the address this node's packets
+// arrive from. The key must match the service's.
+func Query(server string, key []byte, timeout time.Duration) (net.IP, error) {
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, requestSize)
+
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		nonce := make([]byte, nonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+
+		if _, err := conn.Write(newRequest(key, nonce, time.Now())); err != nil {
+			return nil, err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		// A late response to an earlier attempt has a different nonce.
+		ip, err := parseResponse(key, nonce, buf[:n])
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("no response from echo service %s: %s", server, lastErr)
+}
@@ -0,0 +1,23 @@
+package executor
+
+import (
+	"log"
+)
+
+// DryRun logs the commands that would be run without executing them. Rendered
+// configuration files are still written so that they can be inspected.
+type DryRun struct{}
+
+func NewDryRun() *DryRun {
+	return &DryRun{}
+}
+
+func (d *DryRun) Run(name string, arg ...string) error {
+	log.Println("dry run, not running command:", name, arg)
+	return nil
+}
+
+func (d *DryRun) Output(name string, arg ...string) ([]byte, error) {
+	log.Println("dry run, not running command:", name, arg)
+	return []byte{}, nil
+}
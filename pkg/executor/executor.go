@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// Executor runs the external commands (kubeadm, kubectl, systemctl) that civitas
+// needs to manage a node.
+type Executor interface {
+	// Run executes the command, streaming its output to the civitas logs.
+	Run(name string, arg ...string) error
+	// Output executes the command and returns its standard output.
+	Output(name string, arg ...string) ([]byte, error)
+}
+
+// Exec is the Executor that really runs commands on the host.
+type Exec struct {
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func NewExec() *Exec {
+	return &Exec{
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+func (e *Exec) Run(name string, arg ...string) error {
+	log.Println("running command:", name, arg)
+	cmd := exec.Command(name, arg...)
+	cmd.Stdout = e.Stdout
+	cmd.Stderr = e.Stderr
+	return cmd.Run()
+}
+
+func (e *Exec) Output(name string, arg ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+
+	cmd := exec.Command(name, arg...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = e.Stderr
+	err := cmd.Run()
+	return stdout.Bytes(), err
+}
@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"strings"
+	"sync"
+)
+
+// Command is a single invocation recorded by the Fake executor.
+type Command struct {
+	Name string
+	Args []string
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Fake records every command it is asked to run so that tests can assert on them.
+// Outputs and Errors are keyed by the full command line, e.g. "kubeadm version -o short".
+type Fake struct {
+	sync.Mutex
+	Commands []Command
+	Outputs  map[string][]byte
+	Errors   map[string]error
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		Outputs: map[string][]byte{},
+		Errors:  map[string]error{},
+	}
+}
+
+func (f *Fake) record(name string, arg ...string) Command {
+	cmd := Command{
+		Name: name,
+		Args: append([]string{}, arg...),
+	}
+
+	f.Commands = append(f.Commands, cmd)
+	return cmd
+}
+
+func (f *Fake) Run(name string, arg ...string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	cmd := f.record(name, arg...)
+	return f.Errors[cmd.String()]
+}
+
+func (f *Fake) Output(name string, arg ...string) ([]byte, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	cmd := f.record(name, arg...)
+	return f.Outputs[cmd.String()], f.Errors[cmd.String()]
+}
@@ -0,0 +1,209 @@
+package ipfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAPI is the address of the HTTP API of a local IPFS daemon.
+const DefaultAPI = "http://127.0.0.1:5001"
+
+// The routing event type sent by findprovs for each provider found.
+const providerEvent = 4
+
+// The routing event type sent when a DHT query fails.
+const queryErrorEvent = 3
+
+// Client talks to the HTTP API of an IPFS daemon.
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// Peer is an IPFS peer and the multiaddrs it is reachable at.
+type Peer struct {
+	ID    string
+	Addrs []string
+}
+
+type routingEvent struct {
+	Type      int
+	Extra     string
+	Responses []Peer
+}
+
+type apiError struct {
+	Message string
+}
+
+func NewClient(api string) *Client {
+	return &Client{
+		URL: strings.TrimRight(api, "/"),
+		HTTPClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}
+}
+
+// call makes a request to an API command, the caller must close the response body.
+func (c *Client) call(command string, args url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	endpoint := fmt.Sprintf("%s/api/v0/%s?%s", c.URL, command, args.Encode())
+
+	resp, err := c.HTTPClient.Post(endpoint, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+
+	apiErr := apiError{}
+	if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+
+	return nil, &statusError{resp.StatusCode, fmt.Sprintf("ipfs %s: %s", command, apiErr.Message)}
+}
+
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// callRouting calls a routing command, falling back to the older dht command on
+// IPFS daemons that do not have the routing commands.
+func (c *Client) callRouting(command string, args url.Values) (*http.Response, error) {
+	resp, err := c.call("routing/"+command, args, nil, "")
+	if statusErr, ok := err.(*statusError); ok && statusErr.status == http.StatusNotFound {
+		return c.call("dht/"+command, args, nil, "")
+	}
+
+	return resp, err
+}
+
+// ID returns the peer ID of the IPFS daemon.
+func (c *Client) ID() (string, error) {
+	resp, err := c.call("id", url.Values{}, nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	id := Peer{}
+	if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
+		return "", err
+	}
+
+	return id.ID, nil
+}
+
+// BlockPut stores a raw block and returns its key.
+func (c *Client) BlockPut(data []byte) (string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	part, err := writer.CreateFormFile("data", "data")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := part.Write(data); err != nil {
+		return "", err
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	resp, err := c.call("block/put", url.Values{}, body, writer.FormDataContentType())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	block := struct {
+		Key string
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&block); err != nil {
+		return "", err
+	}
+
+	return block.Key, nil
+}
+
+// Provide announces to the DHT that the daemon is a provider of key.
+func (c *Client) Provide(key string) error {
+	resp, err := c.callRouting("provide", url.Values{"arg": {key}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = c.readEvents(resp.Body)
+	return err
+}
+
+// FindProviders returns up to num peers that provide key.
+func (c *Client) FindProviders(key string, num int) ([]Peer, error) {
+	args := url.Values{
+		"arg":           {key},
+		"num-providers": {fmt.Sprintf("%d", num)},
+	}
+
+	resp, err := c.callRouting("findprovs", args)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return c.readEvents(resp.Body)
+}
+
+// readEvents reads a stream of routing events and returns the providers found.
+// Query errors are only returned if no providers were found.
+func (c *Client) readEvents(body io.Reader) ([]Peer, error) {
+	peers := []Peer{}
+	var queryErr error
+
+	decoder := json.NewDecoder(bufio.NewReader(body))
+
+	for {
+		event := routingEvent{}
+		if err := decoder.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch event.Type {
+		case providerEvent:
+			peers = append(peers, event.Responses...)
+		case queryErrorEvent:
+			queryErr = fmt.Errorf("ipfs query error: %s", event.Extra)
+		}
+	}
+
+	if len(peers) == 0 && queryErr != nil {
+		return nil, queryErr
+	}
+
+	return peers, nil
+}
@@ -0,0 +1,142 @@
+package ipfs
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often this node re-announces itself as a provider of the cluster block.
+const republishInterval = 10 * time.Minute
+
+// Provider is a go-discover provider that finds peers through the IPFS DHT.
+//
+// Every node stores a block containing the cluster ID, so all nodes in a cluster
+// provide the same block. The addresses of the other providers of that block are
+// returned as peers.
+type Provider struct {
+	mutex     sync.Mutex
+	published map[string]time.Time
+}
+
+func (p *Provider) Help() string {
+	return `IPFS:
+
+    provider:      "ipfs"
+    cluster_id:    Identifier shared by every node in the cluster, defaults to -cluster-id
+    api:           URL of the IPFS HTTP API, defaults to ` + DefaultAPI + `
+    num_providers: Maximum number of providers to look up, defaults to 20
+`
+}
+
+func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	if args["provider"] != "ipfs" {
+		return nil, fmt.Errorf("discover-ipfs: invalid provider %s", args["provider"])
+	}
+
+	clusterID := args["cluster_id"]
+	if clusterID == "" {
+		return nil, fmt.Errorf("discover-ipfs: cluster_id is required")
+	}
+
+	api := args["api"]
+	if api == "" {
+		api = DefaultAPI
+	}
+
+	numProviders := 20
+	if args["num_providers"] != "" {
+		var err error
+		numProviders, err = strconv.Atoi(args["num_providers"])
+		if err != nil {
+			return nil, fmt.Errorf("discover-ipfs: invalid num_providers: %s", err)
+		}
+	}
+
+	client := NewClient(api)
+
+	self, err := client.ID()
+	if err != nil {
+		return nil, fmt.Errorf("discover-ipfs: %s", err)
+	}
+
+	key, err := client.BlockPut([]byte(clusterID))
+	if err != nil {
+		return nil, fmt.Errorf("discover-ipfs: %s", err)
+	}
+
+	p.publish(client, key, l)
+
+	l.Printf("[DEBUG] discover-ipfs: finding providers of %s", key)
+
+	peers, err := client.FindProviders(key, numProviders)
+	if err != nil {
+		return nil, fmt.Errorf("discover-ipfs: %s", err)
+	}
+
+	addrs := []string{}
+	seen := map[string]bool{}
+
+	for _, peer := range peers {
+		if peer.ID == self {
+			continue
+		}
+
+		for _, maddr := range peer.Addrs {
+			ip := multiaddrIP(maddr)
+			if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || seen[ip.String()] {
+				continue
+			}
+
+			seen[ip.String()] = true
+			addrs = append(addrs, ip.String())
+		}
+	}
+
+	l.Printf("[DEBUG] discover-ipfs: found addresses %v", addrs)
+	return addrs, nil
+}
+
+// publish announces this node as a provider of key in the background if it has
+// not been announced recently. Announcing can take minutes on a cold DHT.
+func (p *Provider) publish(client *Client, key string, l *log.Logger) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.published == nil {
+		p.published = map[string]time.Time{}
+	}
+
+	if time.Since(p.published[key]) < republishInterval {
+		return
+	}
+
+	p.published[key] = time.Now()
+
+	go func() {
+		l.Printf("[DEBUG] discover-ipfs: providing %s", key)
+
+		if err := client.Provide(key); err != nil {
+			l.Printf("[ERR] discover-ipfs: could not provide %s: %s", key, err)
+
+			p.mutex.Lock()
+			delete(p.published, key)
+			p.mutex.Unlock()
+		}
+	}()
+}
+
+// multiaddrIP returns the IP address of an IP multiaddr such as
+// /ip4/10.0.0.1/tcp/4001 or /ip6/fd00::1/tcp/4001, or nil for any other address.
+func multiaddrIP(maddr string) net.IP {
+	parts := strings.Split(maddr, "/")
+	if len(parts) < 3 || parts[0] != "" || (parts[1] != "ip4" && parts[1] != "ip6") {
+		return nil
+	}
+
+	return net.ParseIP(parts[2])
+}
@@ -0,0 +1,134 @@
+package ipfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	testClusterID = "test-cluster"
+	testKey       = "bafkreitestkey"
+	testSelf      = "QmSelf"
+)
+
+// fakeIPFS serves the IPFS API commands used by the provider. If routing is
+// false, the routing commands return 404 like older daemons that only have the
+// dht commands.
+func fakeIPFS(t *testing.T, routing bool, provided chan<- string) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v0/id", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Peer{ID: testSelf})
+	})
+
+	mux.HandleFunc("/api/v0/block/put", func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("data")
+		if err != nil {
+			t.Errorf("block/put without data: %s", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, _ := ioutil.ReadAll(file)
+		if string(data) != testClusterID {
+			t.Errorf("expected block %s, got %s", testClusterID, data)
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"Key": testKey})
+	})
+
+	provide := func(w http.ResponseWriter, r *http.Request) {
+		provided <- r.URL.Query().Get("arg")
+	}
+
+	findProviders := func(w http.ResponseWriter, r *http.Request) {
+		if key := r.URL.Query().Get("arg"); key != testKey {
+			t.Errorf("expected providers of %s to be found, got %s", testKey, key)
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.Encode(routingEvent{Type: queryErrorEvent, Extra: "routing: not found"})
+		encoder.Encode(routingEvent{Type: providerEvent, Responses: []Peer{
+			{ID: testSelf, Addrs: []string{"/ip4/10.0.0.1/tcp/4001"}},
+			{ID: "QmPeer1", Addrs: []string{
+				"/ip4/127.0.0.1/tcp/4001",
+				"/ip4/10.0.0.2/tcp/4001",
+				"/ip4/10.0.0.2/udp/4001/quic",
+				"/ip6/fe80::1/tcp/4001",
+				"/ip6/fd00::2/tcp/4001",
+				"/dns4/example.com/tcp/4001",
+			}},
+		}})
+		encoder.Encode(routingEvent{Type: providerEvent, Responses: []Peer{
+			{ID: "QmPeer2", Addrs: []string{"/ip4/0.0.0.0/tcp/4001", "/ip4/10.0.0.3/tcp/4001"}},
+		}})
+	}
+
+	prefix := "/api/v0/dht/"
+	if routing {
+		prefix = "/api/v0/routing/"
+	}
+
+	mux.HandleFunc(prefix+"provide", provide)
+	mux.HandleFunc(prefix+"findprovs", findProviders)
+
+	return httptest.NewServer(mux)
+}
+
+func TestProviderAddrs(t *testing.T) {
+	for _, routing := range []bool{true, false} {
+		provided := make(chan string, 1)
+		server := fakeIPFS(t, routing, provided)
+
+		provider := &Provider{}
+		addrs, err := provider.Addrs(map[string]string{
+			"provider":   "ipfs",
+			"cluster_id": testClusterID,
+			"api":        server.URL,
+		}, log.New(ioutil.Discard, "", 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"10.0.0.2", "fd00::2", "10.0.0.3"}
+		if !reflect.DeepEqual(addrs, expected) {
+			t.Errorf("routing %v: expected addresses %v, got %v", routing, expected, addrs)
+		}
+
+		select {
+		case key := <-provided:
+			if key != testKey {
+				t.Errorf("routing %v: expected %s to be provided, got %s", routing, testKey, key)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("routing %v: the cluster block was not provided", routing)
+		}
+
+		server.Close()
+	}
+}
+
+func TestProviderAddrsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(apiError{Message: "daemon not running"})
+	}))
+	defer server.Close()
+
+	provider := &Provider{}
+	_, err := provider.Addrs(map[string]string{
+		"provider":   "ipfs",
+		"cluster_id": testClusterID,
+		"api":        server.URL,
+	}, log.New(ioutil.Discard, "", 0))
+
+	if err == nil || err.Error() != "discover-ipfs: ipfs id: daemon not running" {
+		t.Errorf("expected the API error to be returned, got: %v", err)
+	}
+}
@@ -1,11 +1,17 @@
 package kubeadm
 
 import (
-	"github.com/justinbarrick/civitas/pkg/proxy"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	hserf "github.com/hashicorp/serf/serf"
 	"github.com/justinbarrick/civitas/pkg/cluster"
+	"github.com/justinbarrick/civitas/pkg/drain"
+	"github.com/justinbarrick/civitas/pkg/executor"
+	"github.com/justinbarrick/civitas/pkg/proxy"
+	"github.com/justinbarrick/civitas/pkg/vip"
+	"github.com/prometheus/client_golang/prometheus"
 	"io/ioutil"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -13,9 +19,11 @@ import (
 	kubeadm "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm/v1beta1"
 	"log"
 	"math/rand"
+	"net"
 	"os"
-	"os/exec"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -49,42 +57,89 @@ func writeConfig(objs ...runtime.Object) (string, error) {
 	return tmpfile.Name(), nil
 }
 
-func run(name string, arg ...string) error {
-	log.Println("running command:", name, arg)
-	cmd := exec.Command(name, arg...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
 type Kubeadm struct {
-	Token          string
-	CertificateKey string
-	Masters        []string
-	cluster        *cluster.Cluster
-	proxy          *proxy.Proxy
-	controlPlaneIP string
+	Token             string
+	CertificateKey    string
+	Masters           []string
+	ConfigOverlay     string
+	KubernetesVersion string
+	VIPHolder         string
+	// The node that holds the upgrade lock.
+	UpgradeLock string
+	// The version the control plane has been upgraded to with kubeadm upgrade
+	// apply, nodes other than the one that ran it are upgraded once it is set.
+	UpgradedVersion string
+	cluster         *cluster.Cluster
+	proxies         *proxy.Manager
+	controlPlaneIP  string
+	executor        executor.Executor
+	localOverlay    string
+	preflight       []string
+	registration    kubeadm.NodeRegistrationOptions
+	podSubnets      []string
+	serviceSubnets  []string
+	dualStack       bool
+	localVersion    string
+	drainer         *drain.Drainer
+	mutex           sync.Mutex
+	leaving         bool
+	role            string
+	// The Kubernetes version this node was started with or last upgraded to.
+	runningVersion  string
+	upgrading       bool
+	upgradeMutex    sync.Mutex
+	vip             *vip.VIP
+	commandDuration *prometheus.HistogramVec
+	// The version of the kubeadm binary, detected on first use.
+	detectedVersion string
+	versionMutex    sync.Mutex
 }
 
-func NewKubeadm(cluster *cluster.Cluster, controlPlaneIP string) *Kubeadm {
+// APIServerService is the name of the proxied Kubernetes API server service.
+const APIServerService = "apiserver"
+
+func NewKubeadm(c *cluster.Cluster, controlPlaneIP string) *Kubeadm {
+	proxies := proxy.NewManager(c.LocalAddr())
+
+	err := proxies.Add(proxy.Service{
+		Name:            APIServerService,
+		Listen:          net.JoinHostPort(controlPlaneIP, "6444"),
+		Port:            6443,
+		Tags:            map[string]string{cluster.RoleTag: "master"},
+		HealthCheckPath: "/healthz",
+	})
+	if err != nil {
+		log.Fatal("error creating api server proxy:", err)
+	}
+
 	return &Kubeadm{
-		cluster: cluster,
-		proxy: proxy.NewProxy(fmt.Sprintf("%s:6444", controlPlaneIP)),
-		controlPlaneIP: controlPlaneIP,
+		cluster:         c,
+		proxies:         proxies,
+		controlPlaneIP:  controlPlaneIP,
+		executor:        executor.NewExec(),
+		preflight:       DefaultIgnorePreflightErrors,
+		commandDuration: newCommandDuration(),
 	}
 }
 
 func (k *Kubeadm) ClusterConfiguration() *kubeadm.ClusterConfiguration {
+	certSANs := []string{k.controlPlaneIP}
+	if k.vip != nil {
+		certSANs = append(certSANs, k.vip.Address.String())
+	}
+
 	return &kubeadm.ClusterConfiguration{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "ClusterConfiguration",
 			APIVersion: "kubeadm.k8s.io/v1beta1",
 		},
-		KubernetesVersion: "v1.14.0",
+		KubernetesVersion: k.desiredKubernetesVersion(),
 		APIServer: kubeadm.APIServer{
-			CertSANs: []string{k.controlPlaneIP,},
+			CertSANs: certSANs,
 		},
-		ControlPlaneEndpoint: fmt.Sprintf("%s:6444", k.controlPlaneIP),
+		ControlPlaneEndpoint: net.JoinHostPort(k.controlPlaneIP, "6444"),
+		Networking:           k.networking(),
+		FeatureGates:         k.featureGates(),
 	}
 }
 
@@ -96,17 +151,19 @@ func (k *Kubeadm) JoinConfiguration(master bool) *kubeadm.JoinConfiguration {
 		},
 		Discovery: kubeadm.Discovery{
 			BootstrapToken: &kubeadm.BootstrapTokenDiscovery{
-				APIServerEndpoint:        fmt.Sprintf("%s:6444", k.controlPlaneIP),
+				APIServerEndpoint:        net.JoinHostPort(k.controlPlaneIP, "6444"),
 				Token:                    k.Token,
 				UnsafeSkipCAVerification: true,
 			},
 		},
+		NodeRegistration: k.nodeRegistration(),
 	}
 
 	if master {
 		joinConfig.ControlPlane = &kubeadm.JoinControlPlane{
 			LocalAPIEndpoint: kubeadm.APIEndpoint{
-				BindPort: 6443,
+				AdvertiseAddress: k.cluster.LocalAddr(),
+				BindPort:         6443,
 			},
 		}
 	}
@@ -139,21 +196,107 @@ func (k *Kubeadm) InitConfiguration() *kubeadm.InitConfiguration {
 			},
 		},
 		LocalAPIEndpoint: kubeadm.APIEndpoint{
-			BindPort: 6443,
+			AdvertiseAddress: k.cluster.LocalAddr(),
+			BindPort:         6443,
 		},
+		NodeRegistration: k.nodeRegistration(),
+	}
+}
+
+// NodeName returns the name this node is registered with in Kubernetes.
+func (k *Kubeadm) NodeName() string {
+	if k.registration.Name != "" {
+		return k.registration.Name
+	}
+
+	hostName, err := os.Hostname()
+	if err != nil {
+		log.Println("Warning: could not get hostname: ", err)
+	}
+
+	return strings.ToLower(hostName)
+}
+
+// Drain cordons and drains this node and removes it from Kubernetes. If this
+// node's kubeconfig is not allowed to drain it, as on workers, a master is asked
+// to drain it.
+func (k *Kubeadm) Drain() error {
+	if k.drainer == nil {
+		return nil
+	}
+
+	err := k.drainer.Decommission(k.NodeName())
+	if err != drain.ErrNotAuthorized {
+		return err
+	}
+
+	return k.drainFromMaster()
+}
+
+// drainFromMaster asks an active master to drain this node, trying each master
+// until one succeeds.
+func (k *Kubeadm) drainFromMaster() error {
+	// Allow for cordoning and deleting the node as well as evicting its pods.
+	timeout := k.drainer.Timeout() + time.Minute
+	err := errors.New("no master is available to drain this node")
+
+	for _, member := range k.cluster.ActiveMembers() {
+		if member.Name == k.cluster.NodeName || member.Tags[cluster.RoleTag] != "master" {
+			continue
+		}
+
+		log.Println("asking", member.Name, "to drain this node.")
+
+		err = k.cluster.Request(member.Name, drainRequest, []byte(k.NodeName()), timeout)
+		if err == nil {
+			return nil
+		}
+
+		log.Println("error draining this node from", member.Name+":", err)
 	}
+
+	return err
 }
 
+// Reset drains this node and runs kubeadm reset.
 func (k *Kubeadm) Reset() error {
-	return run("kubeadm", "reset", "--force")
+	if err := k.Drain(); err != nil {
+		log.Println("error draining node, continuing with reset:", err)
+	}
+
+	return k.reset()
+}
+
+// reset runs kubeadm reset without draining the node first.
+func (k *Kubeadm) reset() error {
+	return k.runKubeadm("reset", "--force")
 }
 
 func (k *Kubeadm) Kubeadm(args []string, configObjs ...runtime.Object) error {
+	configObjs, err := convertConfig(k.configKubernetesVersion(), configObjs...)
+	if err != nil {
+		return err
+	}
+
+	if k.ConfigOverlay != "" {
+		overlay, err := ParseOverlay([]byte(k.ConfigOverlay))
+		if err != nil {
+			return err
+		}
+
+		configObjs, err = overlay.Apply(configObjs...)
+		if err != nil {
+			return err
+		}
+	}
+
 	configPath, err := writeConfig(configObjs...)
 	if err != nil {
 		return err
 	}
 
+	log.Println("wrote kubeadm configuration to:", configPath)
+
 	if err := k.Reset(); err != nil {
 		return err
 	}
@@ -162,22 +305,23 @@ func (k *Kubeadm) Kubeadm(args []string, configObjs ...runtime.Object) error {
 
 	args = append(args, "--config", configPath)
 
-	// TODO: make configurable
-	for _, preflight := range []string{
-		"FileContent--proc-sys-net-bridge-bridge-nf-call-iptables",
-		"Swap", "SystemVerification",
-	} {
+	for _, preflight := range k.preflight {
 		args = append(args, "--ignore-preflight-errors", preflight)
 	}
 
-	return run("kubeadm", args...)
+	return k.runKubeadm(args...)
 }
 
 func (k *Kubeadm) InitCluster() error {
 	log.Println("initializing as Kubernetes bootstrap node.")
 
+	configVersion, err := configVersionFor(k.configKubernetesVersion())
+	if err != nil {
+		return err
+	}
+
 	return k.Kubeadm([]string{
-		"init", "--experimental-upload-certs",
+		"init", configVersion.uploadCertsFlag,
 		"--certificate-key", k.CertificateKey,
 	}, k.InitConfiguration(), k.ClusterConfiguration(),
 	)
@@ -186,8 +330,14 @@ func (k *Kubeadm) InitCluster() error {
 func (k *Kubeadm) InitMaster() error {
 	log.Println("initializing as Kubernetes master.")
 
+	configVersion, err := configVersionFor(k.configKubernetesVersion())
+	if err != nil {
+		return err
+	}
+
 	return k.Kubeadm([]string{
-		"join", "--certificate-key", k.CertificateKey,
+		"join", configVersion.controlPlaneFlag,
+		"--certificate-key", k.CertificateKey,
 	}, k.JoinConfiguration(true), k.ClusterConfiguration(),
 	)
 }
@@ -211,9 +361,9 @@ func (k *Kubeadm) GenerateCertificateKey() string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func (k *Kubeadm) PickMaster() {
-	members := k.cluster.Members()
-
+// PickMaster adds a random active member that is not already a master to the
+// list of masters, returning false if there are no members left to pick.
+func (k *Kubeadm) PickMaster() bool {
 	rand.Seed(time.Now().Unix())
 
 	picked := map[string]bool{}
@@ -221,13 +371,19 @@ func (k *Kubeadm) PickMaster() {
 		picked[master] = true
 	}
 
-	for {
-		master := members[rand.Intn(len(members))].Name
-		if !picked[master] {
-			k.Masters = append(k.Masters, master)
-			return
+	candidates := []string{}
+	for _, member := range k.cluster.ActiveMembers() {
+		if !picked[member.Name] {
+			candidates = append(candidates, member.Name)
 		}
 	}
+
+	if len(candidates) == 0 {
+		return false
+	}
+
+	k.Masters = append(k.Masters, candidates[rand.Intn(len(candidates))])
+	return true
 }
 
 func (k *Kubeadm) SetBootstrapToken(token string) {
@@ -242,13 +398,113 @@ func (k *Kubeadm) SetCluster(cluster *cluster.Cluster) {
 	k.cluster = cluster
 }
 
+// SetConfigOverlay sets the kubeadm configuration that this node will replicate
+// to the cluster if it is elected leader.
+func (k *Kubeadm) SetConfigOverlay(data []byte) error {
+	overlay, err := ParseOverlay(data)
+	if err != nil {
+		return err
+	}
+
+	if err := overlay.Validate(k.configKubernetesVersion()); err != nil {
+		return err
+	}
+
+	k.localOverlay = string(data)
+	return nil
+}
+
+// SetIgnorePreflightErrors sets the kubeadm preflight checks whose errors are
+// ignored on this node.
+func (k *Kubeadm) SetIgnorePreflightErrors(checks []string) {
+	k.preflight = checks
+}
+
+// SetNodeRegistration sets the options used to register this node with Kubernetes.
+func (k *Kubeadm) SetNodeRegistration(registration NodeRegistration) error {
+	opts, err := registration.Options()
+	if err != nil {
+		return err
+	}
+
+	k.registration = opts
+	return nil
+}
+
+// SetKubernetesVersion sets the Kubernetes version that this node will replicate
+// to the cluster if it is elected leader before a version has been replicated.
+func (k *Kubeadm) SetKubernetesVersion(kubeVersion string) error {
+	if _, err := configVersionFor(kubeVersion); err != nil {
+		return err
+	}
+
+	k.localVersion = kubeVersion
+	return nil
+}
+
+// SetProxyBalancer sets the load balancing strategy used by the control plane
+// proxy.
+func (k *Kubeadm) SetProxyBalancer(strategy string) error {
+	balancer, err := proxy.NewBalancer(strategy, k.cluster.LocalAddr())
+	if err != nil {
+		return err
+	}
+
+	k.proxies.Proxy(APIServerService).Balancer = balancer
+	return nil
+}
+
+// SetProxyDrainTimeout sets how long connections to a removed API server are left
+// open before being closed.
+func (k *Kubeadm) SetProxyDrainTimeout(drainTimeout time.Duration) {
+	k.proxies.Proxy(APIServerService).DrainTimeout = drainTimeout
+}
+
+// AddProxyServices adds additional services to be proxied on this node.
+func (k *Kubeadm) AddProxyServices(services []proxy.Service) error {
+	for _, service := range services {
+		if err := k.proxies.Add(service); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Proxies returns the proxy manager.
+func (k *Kubeadm) Proxies() *proxy.Manager {
+	return k.proxies
+}
+
+// SetVIP enables the floating virtual IP for the control plane.
+func (k *Kubeadm) SetVIP(vip *vip.VIP) {
+	k.vip = vip
+}
+
+func (k *Kubeadm) SetDrainer(drainer *drain.Drainer) {
+	k.drainer = drainer
+}
+
+func (k *Kubeadm) SetExecutor(executor executor.Executor) {
+	k.executor = executor
+
+	k.versionMutex.Lock()
+	k.detectedVersion = ""
+	k.versionMutex.Unlock()
+}
+
 func (k *Kubeadm) IsBootstrap() bool {
-	return k.Masters[0] == k.cluster.NodeName
+	return len(k.Masters) > 0 && k.Masters[0] == k.cluster.NodeName
 }
 
 func (k *Kubeadm) IsMaster() bool {
+	return k.IsMasterNode(k.cluster.NodeName)
+}
+
+// IsMasterNode returns true if the named node is a master.
+func (k *Kubeadm) IsMasterNode(name string) bool {
 	for _, master := range k.Masters {
-		if master == k.cluster.NodeName {
+		if master == name {
 			return true
 		}
 	}
@@ -271,6 +527,10 @@ func (k *Kubeadm) FilterMasters() {
 
 	knownMembers := map[string]bool{}
 	for _, member := range members {
+		if member.Status == hserf.StatusLeaving || member.Status == hserf.StatusLeft || member.Tags[cluster.LeavingTag] != "" {
+			continue
+		}
+
 		knownMembers[member.Name] = true
 	}
 
@@ -288,16 +548,46 @@ func (k *Kubeadm) PickMasters(numMasterNodes int) {
 	k.FilterMasters()
 
 	for len(k.Masters) < numMasterNodes {
-		k.PickMaster()
+		if !k.PickMaster() {
+			return
+		}
 	}
 }
 
 func (k *Kubeadm) ClusterLeader(numMasterNodes int) error {
-	<-k.cluster.NotifyChannel()
+	if !<-k.cluster.NotifyChannel() {
+		return nil
+	}
 
 	log.Println("elected as cluster leader.")
 
+	return k.SendClusterState(numMasterNodes, true)
+}
+
+// ReplaceMasters is called on the leader when membership changes and replaces any
+// masters that have left the cluster.
+func (k *Kubeadm) ReplaceMasters(numMasterNodes int) error {
+	if !k.cluster.Leader() {
+		return nil
+	}
+
+	return k.SendClusterState(numMasterNodes, false)
+}
+
+// SendClusterState picks masters and replicates the cluster state to all nodes. If
+// force is false, the state is only sent if the masters have changed.
+func (k *Kubeadm) SendClusterState(numMasterNodes int, force bool) error {
+	k.mutex.Lock()
+
+	previousMasters := append([]string{}, k.Masters...)
+	previousVIPHolder := k.VIPHolder
 	k.PickMasters(numMasterNodes)
+	k.PickVIPHolder()
+
+	if !force && reflect.DeepEqual(previousMasters, k.Masters) && previousVIPHolder == k.VIPHolder {
+		k.mutex.Unlock()
+		return nil
+	}
 
 	if k.Token == "" {
 		k.Token = k.GenerateBootstrapToken()
@@ -307,29 +597,213 @@ func (k *Kubeadm) ClusterLeader(numMasterNodes int) error {
 		k.CertificateKey = k.GenerateCertificateKey()
 	}
 
-	if err := k.cluster.Send(&k); err != nil {
+	if k.localOverlay != "" {
+		k.ConfigOverlay = k.localOverlay
+	}
+
+	// The version is only picked when the cluster is bootstrapped, after that it
+	// is changed with SetDesiredVersion.
+	if k.KubernetesVersion == "" && k.localVersion != "" {
+		k.KubernetesVersion = k.localVersion
+	} else if k.KubernetesVersion == "" {
+		k.KubernetesVersion = k.configKubernetesVersion()
+	}
+
+	// The state is marshalled while locked, but must be sent unlocked since it
+	// will not be committed until WaitForClusterState has received it.
+	state, err := json.Marshal(k)
+	k.mutex.Unlock()
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return k.cluster.Send(json.RawMessage(state))
+}
+
+// PickVIPHolder chooses the master that holds the virtual IP, keeping the current
+// holder unless it is no longer an active master.
+func (k *Kubeadm) PickVIPHolder() {
+	if k.vip == nil {
+		k.VIPHolder = ""
+		return
+	}
+
+	active := map[string]bool{}
+	for _, member := range k.cluster.ActiveMembers() {
+		active[member.Name] = true
+	}
+
+	if k.IsMasterNode(k.VIPHolder) && active[k.VIPHolder] {
+		return
+	}
+
+	k.VIPHolder = ""
+	for _, master := range k.Masters {
+		if active[master] {
+			k.VIPHolder = master
+			return
+		}
+	}
+}
+
+// UpdateVIP acquires the virtual IP if this node is the holder and releases it
+// otherwise.
+func (k *Kubeadm) UpdateVIP() {
+	if k.vip == nil {
+		return
+	}
+
+	k.mutex.Lock()
+	holder := k.VIPHolder == k.cluster.NodeName
+	k.mutex.Unlock()
+
+	var err error
+	if holder {
+		err = k.vip.Acquire()
+	} else {
+		err = k.vip.Release()
+	}
+
+	if err != nil {
+		log.Println("error updating virtual IP:", err)
+	}
+}
+
+// Role returns the role that this node should have in Kubernetes.
+func (k *Kubeadm) Role() string {
+	if k.IsMaster() {
+		return "master"
+	}
+
+	return "worker"
 }
 
 func (k *Kubeadm) WaitForClusterState() error {
 	clusterStateBytes := <-k.cluster.LogChannel()
 
+	k.mutex.Lock()
 	if err := json.Unmarshal(clusterStateBytes, k); err != nil {
+		k.mutex.Unlock()
 		return err
 	}
 
-	log.Println("got cluster state:", k)
-	k.UpdateAPIProxy()
+	log.Println("got cluster state:", k.Masters)
+
+	role := k.Role()
+	leaving := k.leaving
+	k.mutex.Unlock()
+
+	if err := k.cluster.SetTag(cluster.RoleTag, role); err != nil {
+		log.Println("error advertising role:", err)
+	}
+
+	k.UpdateProxies()
+
+	if !leaving {
+		k.UpdateVIP()
+	}
 
-	return k.StartNode()
+	if leaving {
+		return nil
+	}
+
+	k.mutex.Lock()
+	changed := role != k.role
+	k.role = role
+	kubeVersion := k.KubernetesVersion
+
+	upgrade := ""
+	if !changed {
+		upgrade = k.pendingUpgrade()
+	}
+
+	if upgrade != "" {
+		k.upgrading = true
+	}
+	k.mutex.Unlock()
+
+	// Upgrades are run in the background since they wait for cluster states
+	// received here.
+	if upgrade != "" {
+		go k.upgrade(upgrade)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if err := k.StartNode(); err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	k.runningVersion = kubeVersion
+	k.mutex.Unlock()
+
+	return nil
+}
+
+// waitForHandoff waits until the cluster state no longer lists this node as a
+// master.
+func (k *Kubeadm) waitForHandoff(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		k.mutex.Lock()
+		master := k.IsMaster()
+		k.mutex.Unlock()
+
+		if !master {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for master role to be handed off")
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// Leave gracefully removes this node from the cluster: the node is drained, its
+// master role is handed off to another node, it is reset (which removes it from
+// etcd), and it leaves raft and serf.
+func (k *Kubeadm) Leave(timeout time.Duration) error {
+	log.Println("leaving cluster.")
+
+	k.mutex.Lock()
+	k.leaving = true
+	k.mutex.Unlock()
+
+	if err := k.Drain(); err != nil {
+		log.Println("error draining node:", err)
+	}
+
+	if err := k.cluster.SetLeaving(); err != nil {
+		return err
+	}
+
+	if err := k.waitForHandoff(timeout); err != nil {
+		log.Println(err)
+	}
+
+	if k.vip != nil {
+		if err := k.vip.Release(); err != nil {
+			log.Println("error releasing virtual IP:", err)
+		}
+	}
+
+	// The node was drained before its master role was handed off.
+	if err := k.reset(); err != nil {
+		log.Println("error resetting node:", err)
+	}
+
+	return k.cluster.Leave()
 }
 
 func (k *Kubeadm) Controller(numMasterNodes int) {
-	if err := k.proxy.Run(); err != nil {
-		log.Fatal("error starting api server proxy:", err)
+	if err := k.proxies.Run(); err != nil {
+		log.Fatal(err)
 	}
 
 	go func() {
@@ -347,20 +821,26 @@ func (k *Kubeadm) Controller(numMasterNodes int) {
 			}
 		}
 	}()
-}
 
-func (k *Kubeadm) UpdateAPIProxy() {
-	masterIPs := []string{}
-	members := k.cluster.Members()
-	for _, master := range k.Masters {
-		for _, member := range members {
-			if member.Name != master {
-				continue
-			}
+	go func() {
+		for query := range k.cluster.RequestChannel() {
+			go k.HandleRequest(query)
+		}
+	}()
 
-			masterIPs = append(masterIPs, fmt.Sprintf("%s:6443", member.Addr.String()))
+	go func() {
+		for range k.cluster.MemberChannel() {
+			k.UpdateProxies()
+
+			if err := k.ReplaceMasters(numMasterNodes); err != nil {
+				log.Println("error replacing masters:", err)
+			}
 		}
-	}
+	}()
+}
 
-	k.proxy.Set(masterIPs)
+// UpdateProxies sets the upstreams of each proxied service from the cluster
+// members.
+func (k *Kubeadm) UpdateProxies() {
+	k.proxies.Update(k.cluster.ReachableMembers())
 }
@@ -0,0 +1,361 @@
+package kubeadm
+
+import (
+	"github.com/justinbarrick/civitas/pkg/cluster"
+	"github.com/justinbarrick/civitas/pkg/executor"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const versionCommand = "kubeadm version -o short"
+
+func newTestKubeadm(kubeadmVersion string) (*Kubeadm, *executor.Fake) {
+	fake := executor.NewFake()
+	fake.Outputs[versionCommand] = []byte(kubeadmVersion + "\n")
+
+	k := NewKubeadm(&cluster.Cluster{
+		NodeName: "node-1",
+		Addr:     "10.0.0.1",
+	}, "10.0.0.100")
+	k.SetExecutor(fake)
+	k.SetBootstrapToken("abcdef.0123456789abcdef")
+	k.SetCertificateKey("certificate-key")
+	k.Masters = []string{"node-1"}
+
+	return k, fake
+}
+
+// kubeadmCommands returns the kubeadm commands that were run, without the version
+// checks.
+func kubeadmCommands(fake *executor.Fake) []executor.Command {
+	commands := []executor.Command{}
+
+	for _, command := range fake.Commands {
+		if command.String() != versionCommand {
+			commands = append(commands, command)
+		}
+	}
+
+	return commands
+}
+
+// configFile removes the --config flag from the arguments and returns the
+// contents of the rendered configuration file.
+func configFile(t *testing.T, command *executor.Command) string {
+	for i, arg := range command.Args {
+		if arg != "--config" || i+1 >= len(command.Args) {
+			continue
+		}
+
+		path := command.Args[i+1]
+		defer os.Remove(path)
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		command.Args = append(command.Args[:i], command.Args[i+2:]...)
+		return string(data)
+	}
+
+	t.Fatalf("%s was not passed a configuration file", command)
+	return ""
+}
+
+func preflightArgs() []string {
+	args := []string{}
+	for _, check := range DefaultIgnorePreflightErrors {
+		args = append(args, "--ignore-preflight-errors", check)
+	}
+
+	return args
+}
+
+func TestKubeadmCommands(t *testing.T) {
+	tests := []struct {
+		name           string
+		kubeadmVersion string
+		run            func(k *Kubeadm) error
+		args           []string
+		apiVersion     string
+	}{
+		{
+			name:           "InitCluster v1.14",
+			kubeadmVersion: "v1.14.3",
+			run:            (*Kubeadm).InitCluster,
+			args:           []string{"init", "--experimental-upload-certs", "--certificate-key", "certificate-key"},
+			apiVersion:     "kubeadm.k8s.io/v1beta1",
+		},
+		{
+			name:           "InitCluster v1.15",
+			kubeadmVersion: "v1.15.0",
+			run:            (*Kubeadm).InitCluster,
+			args:           []string{"init", "--upload-certs", "--certificate-key", "certificate-key"},
+			apiVersion:     "kubeadm.k8s.io/v1beta2",
+		},
+		{
+			name:           "InitMaster v1.14",
+			kubeadmVersion: "v1.14.3",
+			run:            (*Kubeadm).InitMaster,
+			args:           []string{"join", "--experimental-control-plane", "--certificate-key", "certificate-key"},
+			apiVersion:     "kubeadm.k8s.io/v1beta1",
+		},
+		{
+			name:           "InitMaster v1.31",
+			kubeadmVersion: "v1.31.1",
+			run:            (*Kubeadm).InitMaster,
+			args:           []string{"join", "--control-plane", "--certificate-key", "certificate-key"},
+			apiVersion:     "kubeadm.k8s.io/v1beta4",
+		},
+		{
+			name:           "InitWorker v1.22",
+			kubeadmVersion: "v1.22.0",
+			run:            (*Kubeadm).InitWorker,
+			args:           []string{"join"},
+			apiVersion:     "kubeadm.k8s.io/v1beta3",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, fake := newTestKubeadm(test.kubeadmVersion)
+
+			if err := test.run(k); err != nil {
+				t.Fatal(err)
+			}
+
+			commands := kubeadmCommands(fake)
+			if len(commands) != 2 {
+				t.Fatalf("expected a reset and one kubeadm command, got: %v", commands)
+			}
+
+			if reset := commands[0].String(); reset != "kubeadm reset --force" {
+				t.Errorf("expected the node to be reset first, got: %s", reset)
+			}
+
+			config := configFile(t, &commands[1])
+			if !strings.Contains(config, "apiVersion: "+test.apiVersion+"\n") {
+				t.Errorf("expected configuration for %s, got:\n%s", test.apiVersion, config)
+			}
+
+			expected := append(append([]string{}, test.args...), preflightArgs()...)
+			if !reflect.DeepEqual(commands[1].Args, expected) {
+				t.Errorf("expected kubeadm %v, got kubeadm %v", expected, commands[1].Args)
+			}
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	k, fake := newTestKubeadm("v1.15.0")
+
+	if err := k.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []executor.Command{
+		{Name: "kubeadm", Args: []string{"reset", "--force"}},
+	}
+
+	if !reflect.DeepEqual(fake.Commands, expected) {
+		t.Errorf("expected %v, got %v", expected, fake.Commands)
+	}
+}
+
+func TestOverlayFollowsConfigVersion(t *testing.T) {
+	k, fake := newTestKubeadm("v1.31.1")
+	k.ConfigOverlay = `apiVersion: kubeadm.k8s.io/v1beta4
+kind: ClusterConfiguration
+apiServer:
+  extraArgs:
+  - name: audit-log-path
+    value: /var/log/audit.log
+`
+
+	if err := k.InitCluster(); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := kubeadmCommands(fake)
+	config := configFile(t, &commands[len(commands)-1])
+	if !strings.Contains(config, "name: audit-log-path") {
+		t.Errorf("expected the overlay to be merged, got:\n%s", config)
+	}
+
+	k, _ = newTestKubeadm("v1.14.3")
+	k.ConfigOverlay = `apiVersion: kubeadm.k8s.io/v1beta4
+kind: ClusterConfiguration
+`
+
+	if err := k.InitCluster(); err == nil {
+		t.Error("expected an overlay for another configuration version to be rejected")
+	}
+}
+
+func TestKubeadmVersionDetectedOnce(t *testing.T) {
+	k, fake := newTestKubeadm("v1.15.0")
+
+	if err := k.InitCluster(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.InitMaster(); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := kubeadmCommands(fake)
+	for i := range commands {
+		if commands[i].Args[0] != "reset" {
+			configFile(t, &commands[i])
+		}
+	}
+
+	detections := len(fake.Commands) - len(commands)
+	if detections != 1 {
+		t.Errorf("expected the kubeadm version to be detected once, it was detected %d times", detections)
+	}
+}
+
+func TestDualStackFeatureGate(t *testing.T) {
+	tests := map[string]bool{
+		"v1.20.4": true,
+		"v1.21.0": false,
+	}
+
+	for kubeadmVersion, gate := range tests {
+		k, _ := newTestKubeadm(kubeadmVersion)
+		k.KubernetesVersion = "v1.20.0"
+
+		if err := k.SetNetworking([]string{"10.244.0.0/16", "fd00:10:244::/56"}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if enabled := k.featureGates()["IPv6DualStack"]; enabled != gate {
+			t.Errorf("kubeadm %s: expected IPv6DualStack %v, got %v", kubeadmVersion, gate, enabled)
+		}
+	}
+}
+
+func TestUpgradeNode(t *testing.T) {
+	tests := []struct {
+		name           string
+		kubeadmVersion string
+		master         bool
+		apply          bool
+		args           []string
+	}{
+		{
+			name:           "apply",
+			kubeadmVersion: "v1.15.3",
+			master:         true,
+			apply:          true,
+			args:           []string{"upgrade", "apply", "v1.15.3", "--yes"},
+		},
+		{
+			name:           "master v1.14",
+			kubeadmVersion: "v1.14.3",
+			master:         true,
+			args:           []string{"upgrade", "node", "experimental-control-plane"},
+		},
+		{
+			name:           "worker v1.14",
+			kubeadmVersion: "v1.14.3",
+			args:           []string{"upgrade", "node", "config", "--kubelet-version", "v1.14.3"},
+		},
+		{
+			name:           "worker v1.15",
+			kubeadmVersion: "v1.15.3",
+			args:           []string{"upgrade", "node"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, fake := newTestKubeadm("v1.14.0")
+
+			// The version detected before kubeadm was upgraded is not used.
+			if _, err := k.kubeadmVersion(); err != nil {
+				t.Fatal(err)
+			}
+			fake.Outputs[versionCommand] = []byte(test.kubeadmVersion + "\n")
+
+			if err := k.upgradeNode(test.kubeadmVersion, test.master, test.apply); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []executor.Command{
+				{Name: "kubeadm", Args: test.args},
+				{Name: "systemctl", Args: []string{"restart", "kubelet"}},
+			}
+
+			if commands := kubeadmCommands(fake); !reflect.DeepEqual(commands, expected) {
+				t.Errorf("expected %v, got %v", expected, commands)
+			}
+		})
+	}
+}
+
+func TestPendingUpgrade(t *testing.T) {
+	tests := []struct {
+		name            string
+		node            string
+		runningVersion  string
+		upgradedVersion string
+		upgrading       bool
+		expected        string
+	}{
+		{
+			name:           "up to date",
+			node:           "node-1",
+			runningVersion: "v1.15.3",
+		},
+		{
+			name:           "not started",
+			node:           "node-1",
+			runningVersion: "",
+		},
+		{
+			name:           "master",
+			node:           "node-1",
+			runningVersion: "v1.14.3",
+			expected:       "v1.15.3",
+		},
+		{
+			name:           "already upgrading",
+			node:           "node-1",
+			runningVersion: "v1.14.3",
+			upgrading:      true,
+		},
+		{
+			name:           "worker before the control plane",
+			node:           "node-2",
+			runningVersion: "v1.14.3",
+		},
+		{
+			name:            "worker after the control plane",
+			node:            "node-2",
+			runningVersion:  "v1.14.3",
+			upgradedVersion: "v1.15.3",
+			expected:        "v1.15.3",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, _ := newTestKubeadm("v1.15.3")
+			k.cluster.NodeName = test.node
+			k.KubernetesVersion = "v1.15.3"
+			k.UpgradedVersion = test.upgradedVersion
+			k.runningVersion = test.runningVersion
+			k.upgrading = test.upgrading
+
+			if pending := k.pendingUpgrade(); pending != test.expected {
+				t.Errorf("expected pending upgrade %q, got %q", test.expected, pending)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package kubeadm
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+func newCommandDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "civitas_kubeadm_command_duration_seconds",
+		Help:    "Duration of kubeadm commands, by command and result.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200},
+	}, []string{"command", "result"})
+}
+
+// runKubeadm runs kubeadm and records how long the command took and whether it
+// succeeded.
+func (k *Kubeadm) runKubeadm(args ...string) error {
+	start := time.Now()
+	err := k.executor.Run("kubeadm", args...)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	k.commandDuration.WithLabelValues(args[0], result).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// Collectors returns the Prometheus collectors for the kubeadm command and proxy
+// metrics.
+func (k *Kubeadm) Collectors() []prometheus.Collector {
+	return append(k.proxies.Collectors(), k.commandDuration)
+}
@@ -0,0 +1,122 @@
+package kubeadm
+
+import (
+	"fmt"
+	"github.com/justinbarrick/civitas/pkg/util"
+	"k8s.io/apimachinery/pkg/util/version"
+	kubeadm "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm/v1beta1"
+	"log"
+	"net"
+	"strings"
+)
+
+// Kubernetes releases where dual-stack networking is supported and where it must
+// be enabled with the IPv6DualStack feature gate.
+var (
+	dualStackMinVersion         = version.MustParseGeneric("1.16.0")
+	dualStackFeatureGateRemoved = version.MustParseGeneric("1.21.0")
+)
+
+// parseSubnets validates a list of CIDRs, which may contain at most one IPv4 and
+// one IPv6 CIDR. It returns true if both families are present.
+func parseSubnets(subnets []string) (bool, error) {
+	families := map[bool]bool{}
+
+	for _, subnet := range subnets {
+		ip, _, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return false, fmt.Errorf("invalid subnet %s: %s", subnet, err)
+		}
+
+		ipv4 := ip.To4() != nil
+		if families[ipv4] {
+			return false, fmt.Errorf("invalid subnets %s: at most one IPv4 and one IPv6 subnet can be given", strings.Join(subnets, ","))
+		}
+		families[ipv4] = true
+	}
+
+	return len(families) == 2, nil
+}
+
+// SetNetworking sets the pod and service subnets. Each is either a single CIDR
+// or an IPv4 and IPv6 CIDR pair for a dual-stack cluster.
+func (k *Kubeadm) SetNetworking(podSubnets, serviceSubnets []string) error {
+	podDualStack, err := parseSubnets(podSubnets)
+	if err != nil {
+		return err
+	}
+
+	serviceDualStack, err := parseSubnets(serviceSubnets)
+	if err != nil {
+		return err
+	}
+
+	if len(serviceSubnets) > 0 && podDualStack != serviceDualStack {
+		return fmt.Errorf("pod subnets %s and service subnets %s must both be dual-stack or single-stack", strings.Join(podSubnets, ","), strings.Join(serviceSubnets, ","))
+	}
+
+	k.podSubnets = podSubnets
+	k.serviceSubnets = serviceSubnets
+	k.dualStack = podDualStack || serviceDualStack
+	return nil
+}
+
+// nodeIP returns the kubelet node IP: this node's address or, on a dual-stack
+// cluster, its IPv4 and IPv6 addresses in the order of the pod subnets.
+func (k *Kubeadm) nodeIP() string {
+	localAddr := k.cluster.LocalAddr()
+	if !k.dualStack {
+		return localAddr
+	}
+
+	other := util.OtherFamilyAddress(localAddr)
+	if other == nil {
+		log.Printf("warning: no address of the other family found on the interface of %s, the node will be single-stack.\n", localAddr)
+		return localAddr
+	}
+
+	ips := []string{localAddr, other.String()}
+
+	local := net.ParseIP(localAddr)
+	if len(k.podSubnets) > 0 {
+		primary, _, _ := net.ParseCIDR(k.podSubnets[0])
+		if (primary.To4() != nil) != (local.To4() != nil) {
+			ips[0], ips[1] = ips[1], ips[0]
+		}
+	}
+
+	return strings.Join(ips, ",")
+}
+
+// networking returns the kubeadm networking configuration.
+func (k *Kubeadm) networking() kubeadm.Networking {
+	return kubeadm.Networking{
+		PodSubnet:     strings.Join(k.podSubnets, ","),
+		ServiceSubnet: strings.Join(k.serviceSubnets, ","),
+	}
+}
+
+// featureGates returns the kubeadm feature gates needed for the configured
+// networking on the kubeadm version configuration is rendered for, since kubeadm
+// rejects feature gates it does not know.
+func (k *Kubeadm) featureGates() map[string]bool {
+	if !k.dualStack {
+		return nil
+	}
+
+	v, err := version.ParseGeneric(k.configKubernetesVersion())
+	if err != nil {
+		return nil
+	}
+
+	if !v.AtLeast(dualStackMinVersion) {
+		log.Printf("warning: dual-stack networking requires Kubernetes %s or newer.\n", dualStackMinVersion)
+		return nil
+	}
+
+	if v.LessThan(dualStackFeatureGateRemoved) {
+		return map[string]bool{"IPv6DualStack": true}
+	}
+
+	return nil
+}
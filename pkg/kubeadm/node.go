@@ -0,0 +1,107 @@
+package kubeadm
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	kubeadm "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm/v1beta1"
+	"strings"
+)
+
+// DefaultIgnorePreflightErrors are the kubeadm preflight checks that are ignored
+// unless configured otherwise.
+var DefaultIgnorePreflightErrors = []string{
+	"FileContent--proc-sys-net-bridge-bridge-nf-call-iptables",
+	"Swap", "SystemVerification",
+}
+
+// NodeRegistration holds the node specific options used when registering this
+// node with Kubernetes.
+type NodeRegistration struct {
+	// Name of the Kubernetes node, defaults to the hostname if empty.
+	Name string
+	// CRISocket is the path to the container runtime socket, e.g.
+	// /run/containerd/containerd.sock.
+	CRISocket string
+	// KubeletExtraArgs in the form key=value.
+	KubeletExtraArgs []string
+	// Taints in the form key=value:Effect or key:Effect.
+	Taints []string
+}
+
+// Options converts the NodeRegistration into kubeadm NodeRegistrationOptions.
+func (n NodeRegistration) Options() (kubeadm.NodeRegistrationOptions, error) {
+	opts := kubeadm.NodeRegistrationOptions{
+		Name:      n.Name,
+		CRISocket: n.CRISocket,
+	}
+
+	if len(n.KubeletExtraArgs) > 0 {
+		opts.KubeletExtraArgs = map[string]string{}
+	}
+
+	for _, arg := range n.KubeletExtraArgs {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return opts, fmt.Errorf("invalid kubelet argument %q, expected key=value", arg)
+		}
+
+		opts.KubeletExtraArgs[strings.TrimPrefix(kv[0], "--")] = kv[1]
+	}
+
+	for _, taintStr := range n.Taints {
+		taint, err := parseTaint(taintStr)
+		if err != nil {
+			return opts, err
+		}
+
+		opts.Taints = append(opts.Taints, taint)
+	}
+
+	return opts, nil
+}
+
+func parseTaint(taintStr string) (corev1.Taint, error) {
+	taint := corev1.Taint{}
+
+	parts := strings.SplitN(taintStr, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return taint, fmt.Errorf("invalid taint %q, expected key=value:Effect", taintStr)
+	}
+
+	effect := corev1.TaintEffect(parts[1])
+	switch effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return taint, fmt.Errorf("invalid taint %q, unknown effect %s", taintStr, parts[1])
+	}
+
+	kv := strings.SplitN(parts[0], "=", 2)
+	taint.Key = kv[0]
+	if len(kv) == 2 {
+		taint.Value = kv[1]
+	}
+	taint.Effect = effect
+
+	return taint, nil
+}
+
+// nodeRegistration returns the registration options for this node. The kubelet
+// is told to use this node's address unless a node IP is configured, so that
+// IPv6, dual-stack and NATed nodes register with the expected address.
+func (k *Kubeadm) nodeRegistration() kubeadm.NodeRegistrationOptions {
+	opts := k.registration
+
+	if k.cluster.LocalAddr() == "" || opts.KubeletExtraArgs["node-ip"] != "" {
+		return opts
+	}
+
+	args := map[string]string{
+		"node-ip": k.nodeIP(),
+	}
+	for key, value := range opts.KubeletExtraArgs {
+		args[key] = value
+	}
+
+	opts.KubeletExtraArgs = args
+	return opts
+}
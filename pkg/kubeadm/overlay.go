@@ -0,0 +1,226 @@
+package kubeadm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	kubeadm "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm/v1beta1"
+	"reflect"
+	"sigs.k8s.io/yaml"
+)
+
+// Kinds generated by civitas that a user overlay may be merged over.
+var overlayKinds = map[string]bool{
+	"ClusterConfiguration": true,
+	"InitConfiguration":    true,
+	"JoinConfiguration":    true,
+}
+
+// Kinds that civitas does not generate, but that are passed through to kubeadm.
+var passthroughKinds = map[string]bool{
+	"KubeletConfiguration":   true,
+	"KubeProxyConfiguration": true,
+}
+
+// Overlay is a user supplied kubeadm configuration file that is merged over the
+// configuration generated by civitas.
+type Overlay struct {
+	patches     map[string][]byte
+	passthrough []runtime.Object
+}
+
+// ParseOverlay parses a multi-document YAML kubeadm configuration file.
+func ParseOverlay(data []byte) (*Overlay, error) {
+	overlay := &Overlay{
+		patches: map[string][]byte{},
+	}
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		jsonDoc, err := yaml.YAMLToJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if string(jsonDoc) == "null" {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(jsonDoc); err != nil {
+			return nil, fmt.Errorf("invalid kubeadm configuration: %s", err)
+		}
+
+		kind := obj.GetKind()
+
+		if overlayKinds[kind] {
+			if overlay.patches[kind] != nil {
+				return nil, fmt.Errorf("invalid kubeadm configuration: %s specified more than once", kind)
+			}
+			overlay.patches[kind] = jsonDoc
+		} else if passthroughKinds[kind] {
+			overlay.passthrough = append(overlay.passthrough, obj)
+		} else {
+			return nil, fmt.Errorf("invalid kubeadm configuration: unsupported kind %s", kind)
+		}
+	}
+
+	return overlay, nil
+}
+
+// Apply merges the overlay over the generated objects and returns the objects
+// that should be handed to kubeadm. Objects must already be converted to the
+// configuration version kubeadm expects, the overlay is merged against it.
+func (o *Overlay) Apply(objs ...runtime.Object) ([]runtime.Object, error) {
+	merged := []runtime.Object{}
+
+	for _, obj := range objs {
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		kind := gvk.Kind
+
+		patch := o.patches[kind]
+		if patch == nil {
+			merged = append(merged, obj)
+			continue
+		}
+
+		if err := o.checkAPIVersion(kind, gvk.GroupVersion().String()); err != nil {
+			return nil, err
+		}
+
+		original, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		// Converted objects have no Go type to take the merge strategy from.
+		if _, ok := obj.(*unstructured.Unstructured); ok {
+			mergedObj, err := mergeUnstructured(original, patch)
+			if err != nil {
+				return nil, fmt.Errorf("could not merge %s: %s", kind, err)
+			}
+
+			merged = append(merged, mergedObj)
+			continue
+		}
+
+		result, err := strategicpatch.StrategicMergePatch(original, patch, obj)
+		if err != nil {
+			return nil, fmt.Errorf("could not merge %s: %s", kind, err)
+		}
+
+		mergedObj := reflect.New(reflect.Indirect(reflect.ValueOf(obj)).Type()).Interface().(runtime.Object)
+
+		decoder := json.NewDecoder(bytes.NewReader(result))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(mergedObj); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", kind, err)
+		}
+
+		merged = append(merged, mergedObj)
+	}
+
+	return append(merged, o.passthrough...), nil
+}
+
+// Validate checks that the overlay can be merged over each kind of generated
+// configuration in the version used for kubeVersion, so that errors are found
+// before kubeadm is run.
+func (o *Overlay) Validate(kubeVersion string) error {
+	objs, err := convertConfig(kubeVersion,
+		&kubeadm.ClusterConfiguration{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "ClusterConfiguration",
+				APIVersion: "kubeadm.k8s.io/v1beta1",
+			},
+		},
+		&kubeadm.InitConfiguration{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "InitConfiguration",
+				APIVersion: "kubeadm.k8s.io/v1beta1",
+			},
+		},
+		&kubeadm.JoinConfiguration{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "JoinConfiguration",
+				APIVersion: "kubeadm.k8s.io/v1beta1",
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.Apply(objs...)
+	return err
+}
+
+func (o *Overlay) checkAPIVersion(kind, apiVersion string) error {
+	patch := &unstructured.Unstructured{}
+	if err := patch.UnmarshalJSON(o.patches[kind]); err != nil {
+		return err
+	}
+
+	if patch.GetAPIVersion() != "" && patch.GetAPIVersion() != apiVersion {
+		return fmt.Errorf("invalid %s: apiVersion %s does not match generated apiVersion %s", kind, patch.GetAPIVersion(), apiVersion)
+	}
+
+	return nil
+}
+
+// mergeUnstructured applies a JSON merge patch (RFC 7386) to an object.
+func mergeUnstructured(original, patch []byte) (*unstructured.Unstructured, error) {
+	originalMap := map[string]interface{}{}
+	if err := json.Unmarshal(original, &originalMap); err != nil {
+		return nil, err
+	}
+
+	patchMap := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &patchMap); err != nil {
+		return nil, err
+	}
+
+	return &unstructured.Unstructured{Object: mergeMaps(originalMap, patchMap)}, nil
+}
+
+// mergeMaps merges patch into original: nested maps are merged, null values
+// remove keys and any other value replaces the original.
+func mergeMaps(original, patch map[string]interface{}) map[string]interface{} {
+	for key, value := range patch {
+		if value == nil {
+			delete(original, key)
+			continue
+		}
+
+		patchValue, patchIsMap := value.(map[string]interface{})
+		originalValue, originalIsMap := original[key].(map[string]interface{})
+		if patchIsMap && originalIsMap {
+			original[key] = mergeMaps(originalValue, patchValue)
+		} else if patchIsMap {
+			original[key] = mergeMaps(map[string]interface{}{}, patchValue)
+		} else {
+			original[key] = value
+		}
+	}
+
+	return original
+}
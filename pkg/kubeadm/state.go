@@ -0,0 +1,167 @@
+package kubeadm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	hserf "github.com/hashicorp/serf/serf"
+	"github.com/justinbarrick/civitas/pkg/cluster"
+	"log"
+)
+
+// The requests handled by HandleRequest.
+const (
+	// Forwarded to the leader to change the desired Kubernetes version.
+	desiredVersionRequest = "desired-version"
+	// Sent to a master to drain a node that cannot drain itself, the payload is
+	// the Kubernetes node name.
+	drainRequest = "drain"
+	// Forwarded to the leader to acquire and release the upgrade lock, the payload
+	// is an upgradeRequest.
+	upgradeLockRequest   = "upgrade-lock"
+	upgradeUnlockRequest = "upgrade-unlock"
+)
+
+// State is the cluster state replicated through raft.
+type State struct {
+	Token             string
+	CertificateKey    string
+	Masters           []string
+	ConfigOverlay     string
+	KubernetesVersion string
+	VIPHolder         string
+	UpgradeLock       string
+	UpgradedVersion   string
+}
+
+// State returns a copy of the replicated cluster state.
+func (k *Kubeadm) State() State {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return State{
+		Token:             k.Token,
+		CertificateKey:    k.CertificateKey,
+		Masters:           append([]string{}, k.Masters...),
+		ConfigOverlay:     k.ConfigOverlay,
+		KubernetesVersion: k.KubernetesVersion,
+		VIPHolder:         k.VIPHolder,
+		UpgradeLock:       k.UpgradeLock,
+		UpgradedVersion:   k.UpgradedVersion,
+	}
+}
+
+// RestoreState replicates a previously saved cluster state, it must be called on
+// the leader.
+func (k *Kubeadm) RestoreState(state State) error {
+	if !k.cluster.Leader() {
+		return cluster.ErrNotLeader
+	}
+
+	if len(state.Masters) == 0 {
+		return errors.New("the cluster state has no masters")
+	}
+
+	if state.KubernetesVersion != "" {
+		if _, err := configVersionFor(state.KubernetesVersion); err != nil {
+			return err
+		}
+	}
+
+	log.Println("restoring cluster state:", state.Masters)
+	return k.cluster.Send(state)
+}
+
+// Leaving returns true once this node has started leaving the cluster.
+func (k *Kubeadm) Leaving() bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.leaving
+}
+
+// CurrentRole returns the role this node has been started as, or an empty string
+// if it has not received the cluster state yet.
+func (k *Kubeadm) CurrentRole() string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.role
+}
+
+// SetDesiredVersion replicates a new desired Kubernetes version, which starts an
+// upgrade of the running nodes. It can be called on any node, the request is
+// forwarded to the leader.
+func (k *Kubeadm) SetDesiredVersion(kubeVersion string) error {
+	if _, err := configVersionFor(kubeVersion); err != nil {
+		return err
+	}
+
+	if err := k.checkUpgrade(kubeVersion); err != nil {
+		return err
+	}
+
+	if !k.cluster.Leader() {
+		return k.cluster.ForwardToLeader(desiredVersionRequest, []byte(kubeVersion))
+	}
+
+	return k.replicateVersion(kubeVersion)
+}
+
+// replicateVersion replicates the current cluster state with a new Kubernetes
+// version, it must be called on the leader.
+func (k *Kubeadm) replicateVersion(kubeVersion string) error {
+	if !k.cluster.Leader() {
+		return cluster.ErrNotLeader
+	}
+
+	if err := k.checkUpgrade(kubeVersion); err != nil {
+		return err
+	}
+
+	state := k.State()
+	if len(state.Masters) == 0 {
+		return errors.New("the cluster state has not been replicated yet")
+	}
+
+	state.KubernetesVersion = kubeVersion
+
+	log.Println("replicating desired Kubernetes version", kubeVersion)
+	return k.cluster.Send(state)
+}
+
+// HandleRequest handles a request sent to this node by another member.
+func (k *Kubeadm) HandleRequest(query *hserf.Query) {
+	var err error
+
+	switch query.Name {
+	case desiredVersionRequest:
+		kubeVersion := string(query.Payload)
+		if _, err = configVersionFor(kubeVersion); err == nil {
+			err = k.replicateVersion(kubeVersion)
+		}
+	case drainRequest:
+		if k.drainer == nil {
+			err = errors.New("draining is disabled on this node")
+		} else {
+			log.Println("draining node", string(query.Payload), "for another member.")
+			err = k.drainer.Decommission(string(query.Payload))
+		}
+	case upgradeLockRequest, upgradeUnlockRequest:
+		request := upgradeRequest{}
+		if err = json.Unmarshal(query.Payload, &request); err == nil {
+			err = k.updateUpgradeLock(query.Name, request)
+		}
+	default:
+		err = fmt.Errorf("unknown request %s", query.Name)
+	}
+
+	response := []byte{}
+	if err != nil {
+		response = []byte(err.Error())
+	}
+
+	if err := query.Respond(response); err != nil {
+		log.Println("error responding to", query.Name, "request:", err)
+	}
+}
@@ -0,0 +1,281 @@
+package kubeadm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/justinbarrick/civitas/pkg/cluster"
+	"k8s.io/apimachinery/pkg/util/version"
+	"log"
+	"time"
+)
+
+// Nodes are upgraded one at a time while holding the upgrade lock, which is kept
+// in the replicated state and granted by the leader. The first master to hold it
+// upgrades the control plane with kubeadm upgrade apply, then the other masters
+// and finally the workers run kubeadm upgrade node.
+
+const (
+	// How often a node retries acquiring the upgrade lock.
+	upgradeLockInterval = 10 * time.Second
+	// How long a node waits for the cluster state granting it the upgrade lock.
+	upgradeLockTimeout = time.Minute
+	// How long a node waits before retrying a failed upgrade.
+	upgradeRetryInterval = time.Minute
+)
+
+// upgradeRequest is the payload of upgrade lock requests.
+type upgradeRequest struct {
+	Node string
+	// The version the control plane was upgraded to while the lock was held, set
+	// when it is released.
+	AppliedVersion string
+}
+
+// pendingUpgrade returns the version this node should be upgraded to, or an empty
+// string if it is up to date or cannot be upgraded yet. It must be called with
+// k.mutex held.
+func (k *Kubeadm) pendingUpgrade() string {
+	if k.runningVersion == "" || k.runningVersion == k.KubernetesVersion || k.upgrading || k.leaving {
+		return ""
+	}
+
+	// Workers are upgraded after the control plane.
+	if !k.IsMaster() && k.UpgradedVersion != k.KubernetesVersion {
+		return ""
+	}
+
+	return k.KubernetesVersion
+}
+
+// upgradeCurrent returns true while kubeVersion is still the version this node
+// should be upgraded to.
+func (k *Kubeadm) upgradeCurrent(kubeVersion string) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.KubernetesVersion == kubeVersion && !k.leaving
+}
+
+// upgrade upgrades this node to kubeVersion, retrying until it succeeds or another
+// version is set.
+func (k *Kubeadm) upgrade(kubeVersion string) {
+	defer func() {
+		k.mutex.Lock()
+		k.upgrading = false
+		k.mutex.Unlock()
+	}()
+
+	for k.upgradeCurrent(kubeVersion) {
+		err := k.upgradeWithLock(kubeVersion)
+		if err == nil {
+			return
+		}
+
+		log.Println("error upgrading to Kubernetes", kubeVersion+":", err)
+		time.Sleep(upgradeRetryInterval)
+	}
+}
+
+// upgradeWithLock acquires the upgrade lock, upgrades this node and releases it.
+func (k *Kubeadm) upgradeWithLock(kubeVersion string) error {
+	if err := k.acquireUpgradeLock(kubeVersion); err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	apply := k.UpgradedVersion != kubeVersion
+	master := k.IsMaster()
+	k.mutex.Unlock()
+
+	release := upgradeRequest{Node: k.cluster.NodeName}
+
+	var err error
+	if apply && !master {
+		err = errors.New("the control plane has not been upgraded yet")
+	} else {
+		err = k.upgradeNode(kubeVersion, master, apply)
+	}
+
+	if err == nil {
+		log.Println("upgraded to Kubernetes", kubeVersion)
+
+		k.mutex.Lock()
+		k.runningVersion = kubeVersion
+		k.mutex.Unlock()
+
+		if apply {
+			release.AppliedVersion = kubeVersion
+		}
+	}
+
+	if err := k.upgradeLockRequest(upgradeUnlockRequest, release); err != nil {
+		log.Println("error releasing the upgrade lock:", err)
+	}
+
+	return err
+}
+
+// acquireUpgradeLock waits until this node holds the upgrade lock and has
+// received the cluster state granting it.
+func (k *Kubeadm) acquireUpgradeLock(kubeVersion string) error {
+	for {
+		err := k.upgradeLockRequest(upgradeLockRequest, upgradeRequest{Node: k.cluster.NodeName})
+		if err == nil {
+			break
+		}
+
+		if !k.upgradeCurrent(kubeVersion) {
+			return fmt.Errorf("the desired version changed while waiting for the upgrade lock")
+		}
+
+		log.Println("waiting for the upgrade lock:", err)
+		time.Sleep(upgradeLockInterval)
+	}
+
+	// The state granting the lock follows the state recording any earlier
+	// upgrade of the control plane.
+	deadline := time.Now().Add(upgradeLockTimeout)
+	for {
+		k.mutex.Lock()
+		held := k.UpgradeLock == k.cluster.NodeName
+		k.mutex.Unlock()
+
+		if held {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the cluster state granting the upgrade lock")
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// upgradeNode runs kubeadm upgrade apply if apply is true and kubeadm upgrade node
+// otherwise, then restarts the kubelet.
+func (k *Kubeadm) upgradeNode(kubeVersion string, master, apply bool) error {
+	// kubeadm is upgraded before the version is set.
+	k.versionMutex.Lock()
+	k.detectedVersion = ""
+	k.versionMutex.Unlock()
+
+	configVersion, err := configVersionFor(k.configKubernetesVersion())
+	if err != nil {
+		return err
+	}
+
+	args := configVersion.upgradeNodeArgs(master, kubeVersion)
+	if apply {
+		log.Println("upgrading the control plane to Kubernetes", kubeVersion)
+		args = []string{"upgrade", "apply", kubeVersion, "--yes"}
+	} else {
+		log.Println("upgrading node to Kubernetes", kubeVersion)
+	}
+
+	if err := k.runKubeadm(args...); err != nil {
+		return err
+	}
+
+	return k.executor.Run("systemctl", "restart", "kubelet")
+}
+
+// upgradeLockRequest sends an upgrade lock request to the leader.
+func (k *Kubeadm) upgradeLockRequest(name string, request upgradeRequest) error {
+	if k.cluster.Leader() {
+		return k.updateUpgradeLock(name, request)
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	return k.cluster.ForwardToLeader(name, payload)
+}
+
+// updateUpgradeLock grants or releases the upgrade lock, it must be called on the
+// leader. A lock held by a node that is no longer active is granted to another.
+func (k *Kubeadm) updateUpgradeLock(name string, request upgradeRequest) error {
+	if !k.cluster.Leader() {
+		return cluster.ErrNotLeader
+	}
+
+	k.upgradeMutex.Lock()
+	defer k.upgradeMutex.Unlock()
+
+	state := k.State()
+	if len(state.Masters) == 0 {
+		return errors.New("the cluster state has not been replicated yet")
+	}
+
+	switch name {
+	case upgradeLockRequest:
+		if holder := state.UpgradeLock; holder != "" && holder != request.Node && k.activeMember(holder) {
+			return fmt.Errorf("the upgrade lock is held by %s", holder)
+		}
+
+		state.UpgradeLock = request.Node
+	case upgradeUnlockRequest:
+		if state.UpgradeLock == request.Node {
+			state.UpgradeLock = ""
+		}
+
+		if request.AppliedVersion != "" {
+			state.UpgradedVersion = request.AppliedVersion
+		}
+	}
+
+	if err := k.cluster.Send(state); err != nil {
+		return err
+	}
+
+	// Set right away so that the next request sees it even if the state has not
+	// been received yet.
+	k.mutex.Lock()
+	k.UpgradeLock = state.UpgradeLock
+	k.UpgradedVersion = state.UpgradedVersion
+	k.mutex.Unlock()
+
+	return nil
+}
+
+// activeMember returns true if the named node is an active member.
+func (k *Kubeadm) activeMember(name string) bool {
+	for _, member := range k.cluster.ActiveMembers() {
+		if member.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkUpgrade returns an error if the cluster cannot be upgraded from the
+// current desired version to kubeVersion.
+func (k *Kubeadm) checkUpgrade(kubeVersion string) error {
+	k.mutex.Lock()
+	current := k.KubernetesVersion
+	k.mutex.Unlock()
+
+	if current == "" {
+		return nil
+	}
+
+	from, err := version.ParseGeneric(current)
+	if err != nil {
+		return nil
+	}
+
+	to, err := version.ParseGeneric(kubeVersion)
+	if err != nil {
+		return err
+	}
+
+	if to.LessThan(from) {
+		return fmt.Errorf("cannot downgrade from Kubernetes %s to %s", current, kubeVersion)
+	}
+
+	return nil
+}
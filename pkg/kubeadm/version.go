@@ -0,0 +1,266 @@
+package kubeadm
+
+import (
+	"encoding/json"
+	"fmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/version"
+	"log"
+	"sort"
+	"strings"
+)
+
+// DefaultKubernetesVersion is the Kubernetes version used if no version is
+// configured and the kubeadm version cannot be detected.
+const DefaultKubernetesVersion = "v1.14.0"
+
+// configVersion describes how to render configuration for a range of kubeadm
+// releases.
+type configVersion struct {
+	// The first kubeadm release that supports this configuration.
+	minVersion *version.Version
+	// The kubeadm configuration API version.
+	apiVersion string
+	// The flag used to upload control plane certificates on init.
+	uploadCertsFlag string
+	// The flag used to join a node as a control plane node.
+	controlPlaneFlag string
+	// Returns the arguments to upgrade a node after the control plane has been
+	// upgraded with kubeadm upgrade apply.
+	upgradeNodeArgs func(master bool, kubeVersion string) []string
+	// Converts an object from the previous configuration version.
+	convert func(kind string, obj *unstructured.Unstructured) error
+}
+
+// Configuration versions, newest last. Objects are generated as v1beta1 and
+// converted through each version up to the one supported by kubeadm.
+var configVersions = []configVersion{
+	{
+		minVersion:       version.MustParseGeneric("1.13.0"),
+		apiVersion:       "kubeadm.k8s.io/v1beta1",
+		uploadCertsFlag:  "--experimental-upload-certs",
+		controlPlaneFlag: "--experimental-control-plane",
+		upgradeNodeArgs:  upgradeNodeArgsV1beta1,
+	},
+	{
+		minVersion:       version.MustParseGeneric("1.15.0"),
+		apiVersion:       "kubeadm.k8s.io/v1beta2",
+		uploadCertsFlag:  "--upload-certs",
+		controlPlaneFlag: "--control-plane",
+		upgradeNodeArgs:  upgradeNodeArgsV1beta2,
+	},
+	{
+		minVersion:       version.MustParseGeneric("1.22.0"),
+		apiVersion:       "kubeadm.k8s.io/v1beta3",
+		uploadCertsFlag:  "--upload-certs",
+		controlPlaneFlag: "--control-plane",
+		upgradeNodeArgs:  upgradeNodeArgsV1beta2,
+		convert:          convertV1beta3,
+	},
+	{
+		minVersion:       version.MustParseGeneric("1.31.0"),
+		apiVersion:       "kubeadm.k8s.io/v1beta4",
+		uploadCertsFlag:  "--upload-certs",
+		controlPlaneFlag: "--control-plane",
+		upgradeNodeArgs:  upgradeNodeArgsV1beta2,
+		convert:          convertV1beta4,
+	},
+}
+
+// configVersionFor returns the newest configuration version supported by the
+// given Kubernetes version.
+func configVersionFor(kubeVersion string) (configVersion, error) {
+	v, err := version.ParseGeneric(kubeVersion)
+	if err != nil {
+		return configVersion{}, err
+	}
+
+	if !v.AtLeast(configVersions[0].minVersion) {
+		return configVersion{}, fmt.Errorf("kubeadm %s is not supported, at least %s is required", kubeVersion, configVersions[0].minVersion)
+	}
+
+	var selected configVersion
+	for _, cv := range configVersions {
+		if v.AtLeast(cv.minVersion) {
+			selected = cv
+		}
+	}
+
+	return selected, nil
+}
+
+// convertConfig converts generated v1beta1 objects to the configuration version
+// for kubeVersion. Objects that are not kubeadm configuration are unchanged.
+func convertConfig(kubeVersion string, objs ...runtime.Object) ([]runtime.Object, error) {
+	target, err := configVersionFor(kubeVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := []runtime.Object{}
+
+	for _, obj := range objs {
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		if gvk.GroupVersion().String() != configVersions[0].apiVersion || target.apiVersion == configVersions[0].apiVersion {
+			converted = append(converted, obj)
+			continue
+		}
+
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+
+		for _, cv := range configVersions[1:] {
+			if cv.convert != nil {
+				if err := cv.convert(gvk.Kind, u); err != nil {
+					return nil, err
+				}
+			}
+
+			u.SetAPIVersion(cv.apiVersion)
+
+			if cv.apiVersion == target.apiVersion {
+				break
+			}
+		}
+
+		converted = append(converted, u)
+	}
+
+	return converted, nil
+}
+
+// Before 1.15, kubeadm upgrade node had separate commands for masters and
+// workers.
+func upgradeNodeArgsV1beta1(master bool, kubeVersion string) []string {
+	if master {
+		return []string{"upgrade", "node", "experimental-control-plane"}
+	}
+
+	return []string{"upgrade", "node", "config", "--kubelet-version", kubeVersion}
+}
+
+// Since 1.15, kubeadm upgrade node detects whether the node is a master.
+func upgradeNodeArgsV1beta2(master bool, kubeVersion string) []string {
+	return []string{"upgrade", "node"}
+}
+
+// v1beta3 removed the DNS add-on type, CoreDNS is the only supported add-on.
+func convertV1beta3(kind string, obj *unstructured.Unstructured) error {
+	if kind == "ClusterConfiguration" {
+		unstructured.RemoveNestedField(obj.Object, "dns", "type")
+		unstructured.RemoveNestedField(obj.Object, "useHyperKubeImage")
+	}
+
+	return nil
+}
+
+// v1beta4 changed extra arguments from a map to a list of name and value pairs
+// and moved the control plane timeout out of the ClusterConfiguration.
+func convertV1beta4(kind string, obj *unstructured.Unstructured) error {
+	var argPaths [][]string
+
+	switch kind {
+	case "ClusterConfiguration":
+		argPaths = [][]string{
+			{"apiServer", "extraArgs"},
+			{"controllerManager", "extraArgs"},
+			{"scheduler", "extraArgs"},
+			{"etcd", "local", "extraArgs"},
+		}
+
+		if _, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "apiServer", "timeoutForControlPlane"); found {
+			log.Println("warning: apiServer.timeoutForControlPlane is not supported by kubeadm.k8s.io/v1beta4, ignoring.")
+			unstructured.RemoveNestedField(obj.Object, "apiServer", "timeoutForControlPlane")
+		}
+	case "InitConfiguration", "JoinConfiguration":
+		argPaths = [][]string{
+			{"nodeRegistration", "kubeletExtraArgs"},
+		}
+	}
+
+	for _, path := range argPaths {
+		args, found, err := unstructured.NestedStringMap(obj.Object, path...)
+		if err != nil {
+			return err
+		} else if !found {
+			continue
+		}
+
+		names := []string{}
+		for name := range args {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		argList := []interface{}{}
+		for _, name := range names {
+			argList = append(argList, map[string]interface{}{
+				"name":  name,
+				"value": args[name],
+			})
+		}
+
+		if err := unstructured.SetNestedSlice(obj.Object, argList, path...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// kubeadmVersion returns the version of the installed kubeadm binary. It is only
+// detected once, failures are retried on the next call.
+func (k *Kubeadm) kubeadmVersion() (string, error) {
+	k.versionMutex.Lock()
+	defer k.versionMutex.Unlock()
+
+	if k.detectedVersion != "" {
+		return k.detectedVersion, nil
+	}
+
+	output, err := k.executor.Output("kubeadm", "version", "-o", "short")
+	if err != nil {
+		return "", err
+	}
+
+	k.detectedVersion = strings.TrimSpace(string(output))
+	return k.detectedVersion, nil
+}
+
+// configKubernetesVersion returns the version that configuration should be
+// rendered for: the installed kubeadm version, or the desired Kubernetes version
+// if kubeadm's version cannot be detected.
+func (k *Kubeadm) configKubernetesVersion() string {
+	kubeVersion, err := k.kubeadmVersion()
+	if err == nil && kubeVersion != "" {
+		return kubeVersion
+	}
+
+	if err != nil {
+		log.Println("could not detect kubeadm version:", err)
+	}
+
+	return k.desiredKubernetesVersion()
+}
+
+// desiredKubernetesVersion returns the Kubernetes version the cluster should run.
+func (k *Kubeadm) desiredKubernetesVersion() string {
+	if k.KubernetesVersion != "" {
+		return k.KubernetesVersion
+	}
+
+	// Before the cluster state is replicated, the version this node would pick.
+	if k.localVersion != "" {
+		return k.localVersion
+	}
+
+	return DefaultKubernetesVersion
+}
@@ -3,17 +3,23 @@ package lock
 import (
 	"errors"
 	"github.com/minio/dsync"
+	"github.com/prometheus/client_golang/prometheus"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
 )
 
+// ErrNotEnoughNodes is returned if the lock is attempted before the initial nodes
+// have joined.
+var ErrNotEnoughNodes = errors.New("not enough nodes")
+
 type Lock struct {
 	initialNodes int
 	lockClients  []dsync.NetLocker
 	ds           *dsync.Dsync
 	dm           *dsync.DRWMutex
+	attempts     *prometheus.CounterVec
 }
 
 func NewLock(rpcAddr string, initialNodes int) *Lock {
@@ -34,6 +40,10 @@ func NewLock(rpcAddr string, initialNodes int) *Lock {
 
 	return &Lock{
 		initialNodes: initialNodes,
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "civitas_lock_attempts_total",
+			Help: "Number of attempts to acquire the bootstrap lock, by result.",
+		}, []string{"result"}),
 	}
 }
 
@@ -47,9 +57,32 @@ func (l *Lock) AddNode(node dsync.NetLocker) {
 	l.lockClients = append(l.lockClients, node)
 }
 
+// Collectors returns the Prometheus collectors for the lock's metrics.
+func (l *Lock) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{l.attempts}
+}
+
+// Lock tries to acquire the bootstrap lock without blocking and records the
+// result of the attempt.
 func (l *Lock) Lock() (bool, error) {
+	acquired, err := l.lock()
+
+	result := "contended"
+	if err == ErrNotEnoughNodes {
+		result = "not_enough_nodes"
+	} else if err != nil {
+		result = "error"
+	} else if acquired {
+		result = "acquired"
+	}
+
+	l.attempts.WithLabelValues(result).Inc()
+	return acquired, err
+}
+
+func (l *Lock) lock() (bool, error) {
 	if len(l.lockClients) < l.initialNodes {
-		return false, errors.New("not enough nodes")
+		return false, ErrNotEnoughNodes
 	}
 
 	var err error
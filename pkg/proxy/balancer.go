@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+const (
+	RoundRobin       = "round-robin"
+	LeastConnections = "least-connections"
+	LocalFirst       = "local-first"
+)
+
+// Balancer chooses which upstream a new connection is routed to.
+type Balancer interface {
+	// Pick returns one of the candidates, which are all healthy. There is always
+	// at least one candidate.
+	Pick(candidates []*Upstream) *Upstream
+}
+
+// NewBalancer returns the balancer for strategy. localAddr is the address of this
+// node, used by the local-first strategy.
+func NewBalancer(strategy string, localAddr string) (Balancer, error) {
+	switch strategy {
+	case RoundRobin:
+		return &roundRobin{}, nil
+	case LeastConnections:
+		return &leastConnections{}, nil
+	case LocalFirst:
+		return &localFirst{
+			localAddr: localAddr,
+			fallback:  &roundRobin{},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy: %s", strategy)
+	}
+}
+
+// roundRobin routes each connection to the next upstream in turn.
+type roundRobin struct {
+	mutex sync.Mutex
+	next  int
+}
+
+func (r *roundRobin) Pick(candidates []*Upstream) *Upstream {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	u := candidates[r.next%len(candidates)]
+	r.next++
+	return u
+}
+
+// leastConnections routes each connection to the upstream with the fewest active
+// connections, the first upstream is preferred on a tie.
+type leastConnections struct{}
+
+func (l *leastConnections) Pick(candidates []*Upstream) *Upstream {
+	picked := candidates[0]
+
+	for _, u := range candidates[1:] {
+		if u.ActiveConnections() < picked.ActiveConnections() {
+			picked = u
+		}
+	}
+
+	return picked
+}
+
+// localFirst routes connections to the upstream on this node if there is one, and
+// otherwise falls back to another balancer.
+type localFirst struct {
+	localAddr string
+	fallback  Balancer
+}
+
+func (l *localFirst) Pick(candidates []*Upstream) *Upstream {
+	for _, u := range candidates {
+		host, _, err := net.SplitHostPort(u.addr)
+		if err == nil && host == l.localAddr {
+			return u
+		}
+	}
+
+	return l.fallback.Pick(candidates)
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+)
+
+// testUpstreams returns upstreams with the given number of active connections.
+func testUpstreams(addrs []string, conns []int) []*Upstream {
+	upstreams := []*Upstream{}
+
+	for i, addr := range addrs {
+		u := newUpstream(addr)
+		for j := 0; j < conns[i]; j++ {
+			c, _ := net.Pipe()
+			u.addConn(c)
+		}
+
+		upstreams = append(upstreams, u)
+	}
+
+	return upstreams
+}
+
+func TestRoundRobin(t *testing.T) {
+	balancer, err := NewBalancer(RoundRobin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstreams := testUpstreams([]string{"10.0.0.1:6443", "10.0.0.2:6443"}, []int{0, 0})
+
+	for i, expected := range []string{"10.0.0.1:6443", "10.0.0.2:6443", "10.0.0.1:6443"} {
+		if picked := balancer.Pick(upstreams).Addr(); picked != expected {
+			t.Errorf("pick %d: expected %s, got %s", i, expected, picked)
+		}
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	balancer, err := NewBalancer(LeastConnections, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		conns    []int
+		expected string
+	}{
+		{[]int{2, 1, 3}, "10.0.0.2:6443"},
+		{[]int{1, 1, 0}, "10.0.0.3:6443"},
+		// The first upstream wins a tie.
+		{[]int{1, 1, 1}, "10.0.0.1:6443"},
+	}
+
+	for _, test := range tests {
+		upstreams := testUpstreams([]string{"10.0.0.1:6443", "10.0.0.2:6443", "10.0.0.3:6443"}, test.conns)
+
+		if picked := balancer.Pick(upstreams).Addr(); picked != test.expected {
+			t.Errorf("connections %v: expected %s, got %s", test.conns, test.expected, picked)
+		}
+	}
+}
+
+func TestLocalFirst(t *testing.T) {
+	balancer, err := NewBalancer(LocalFirst, "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstreams := testUpstreams([]string{"10.0.0.1:6443", "10.0.0.2:6443", "10.0.0.3:6443"}, []int{0, 0, 0})
+
+	for i := 0; i < 3; i++ {
+		if picked := balancer.Pick(upstreams).Addr(); picked != "10.0.0.2:6443" {
+			t.Errorf("expected the local upstream to be picked, got %s", picked)
+		}
+	}
+
+	// Without a local upstream, connections are balanced round-robin.
+	remote := []*Upstream{upstreams[0], upstreams[2]}
+	for i, expected := range []string{"10.0.0.1:6443", "10.0.0.3:6443"} {
+		if picked := balancer.Pick(remote).Addr(); picked != expected {
+			t.Errorf("pick %d: expected %s, got %s", i, expected, picked)
+		}
+	}
+}
+
+func TestUnknownBalancer(t *testing.T) {
+	if _, err := NewBalancer("random", ""); err == nil {
+		t.Error("expected an unknown strategy to be rejected")
+	}
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sync/atomic"
+)
+
+// UpstreamStatus describes the state of an upstream.
+type UpstreamStatus struct {
+	Address           string `json:"address"`
+	Healthy           bool   `json:"healthy"`
+	Draining          bool   `json:"draining"`
+	ActiveConnections int    `json:"activeConnections"`
+	BytesSent         uint64 `json:"bytesSent"`
+	BytesReceived     uint64 `json:"bytesReceived"`
+	DialErrors        uint64 `json:"dialErrors"`
+}
+
+// ProxyStatus describes the state of a proxy listener and its upstreams.
+type ProxyStatus struct {
+	Name      string           `json:"name"`
+	Address   string           `json:"address"`
+	Upstreams []UpstreamStatus `json:"upstreams"`
+}
+
+func (u *Upstream) Status() UpstreamStatus {
+	return UpstreamStatus{
+		Address:           u.addr,
+		Healthy:           u.Healthy(),
+		Draining:          u.Draining(),
+		ActiveConnections: u.ActiveConnections(),
+		BytesSent:         atomic.LoadUint64(&u.bytesSent),
+		BytesReceived:     atomic.LoadUint64(&u.bytesReceived),
+		DialErrors:        atomic.LoadUint64(&u.dialErrors),
+	}
+}
+
+// Status returns the state of the proxy and each of its upstreams.
+func (p *Proxy) Status() ProxyStatus {
+	p.mutex.Lock()
+	upstreams := append([]*Upstream{}, p.upstreams...)
+	for _, u := range p.draining {
+		upstreams = append(upstreams, u)
+	}
+	p.mutex.Unlock()
+
+	status := ProxyStatus{
+		Name:      p.Name,
+		Address:   p.address,
+		Upstreams: []UpstreamStatus{},
+	}
+
+	for _, u := range upstreams {
+		status.Upstreams = append(status.Upstreams, u.Status())
+	}
+
+	return status
+}
+
+// StatusHandler serves the status of the proxy as JSON.
+func (p *Proxy) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.Status())
+	})
+}
+
+// collector exports the state of a proxy's upstreams as Prometheus metrics.
+type collector struct {
+	proxy             *Proxy
+	activeConnections *prometheus.Desc
+	bytes             *prometheus.Desc
+	dialErrors        *prometheus.Desc
+	healthy           *prometheus.Desc
+}
+
+// Collector returns a Prometheus collector for the proxy's metrics.
+func (p *Proxy) Collector() prometheus.Collector {
+	labels := prometheus.Labels{"service": p.Name, "listener": p.address}
+
+	return &collector{
+		proxy: p,
+		activeConnections: prometheus.NewDesc(
+			"civitas_proxy_upstream_active_connections",
+			"Number of connections currently proxied to the upstream.",
+			[]string{"upstream"}, labels,
+		),
+		bytes: prometheus.NewDesc(
+			"civitas_proxy_upstream_bytes_total",
+			"Bytes proxied to (sent) and from (received) the upstream.",
+			[]string{"upstream", "direction"}, labels,
+		),
+		dialErrors: prometheus.NewDesc(
+			"civitas_proxy_upstream_dial_errors_total",
+			"Number of errors connecting to the upstream.",
+			[]string{"upstream"}, labels,
+		),
+		healthy: prometheus.NewDesc(
+			"civitas_proxy_upstream_healthy",
+			"Whether the upstream is passing health checks.",
+			[]string{"upstream"}, labels,
+		),
+	}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeConnections
+	ch <- c.bytes
+	ch <- c.dialErrors
+	ch <- c.healthy
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	for _, u := range c.proxy.Status().Upstreams {
+		healthy := 0.0
+		if u.Healthy {
+			healthy = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.activeConnections, prometheus.GaugeValue, float64(u.ActiveConnections), u.Address)
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.CounterValue, float64(u.BytesSent), u.Address, "sent")
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.CounterValue, float64(u.BytesReceived), u.Address, "received")
+		ch <- prometheus.MustNewConstMetric(c.dialErrors, prometheus.CounterValue, float64(u.DialErrors), u.Address)
+		ch <- prometheus.MustNewConstMetric(c.healthy, prometheus.GaugeValue, healthy, u.Address)
+	}
+}
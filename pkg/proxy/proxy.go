@@ -3,47 +3,187 @@ package proxy
 import (
 	"github.com/google/tcpproxy"
 	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Proxy struct {
-	proxy tcpproxy.Proxy
-	upstreams map[string]int
-	address string
+	// Name of the proxied service.
+	Name string
+	// How often each upstream is health checked.
+	HealthCheckInterval time.Duration
+	// Timeout for connecting to an upstream, both for health checks and for
+	// proxied connections.
+	HealthCheckTimeout time.Duration
+	// If set, the path requested over TLS to health check each upstream, e.g.
+	// /healthz. If empty, only a TCP connection is attempted.
+	HealthCheckPath string
+	// Number of consecutive failed health checks before an upstream is
+	// considered unhealthy.
+	FailureThreshold int
+	// Chooses the upstream for each connection, defaults to round-robin.
+	Balancer Balancer
+	// How long connections to a removed upstream are left open before being
+	// closed.
+	DrainTimeout time.Duration
+
+	mutex     sync.Mutex
+	proxy     tcpproxy.Proxy
+	upstreams []*Upstream
+	draining  map[string]*Upstream
+	address   string
 }
 
 func NewProxy(address string) *Proxy {
 	p := &Proxy{
-		address: address,
-		proxy: tcpproxy.Proxy{},
-		upstreams: map[string]int{},
+		HealthCheckInterval: time.Second,
+		HealthCheckTimeout:  time.Second,
+		FailureThreshold:    2,
+		Balancer:            &roundRobin{},
+		DrainTimeout:        2 * time.Minute,
+		draining:            map[string]*Upstream{},
+		address:             address,
+		proxy:               tcpproxy.Proxy{},
 	}
 
-	p.Set([]string{"0.0.0.0",})
+	p.proxy.AddRoute(address, p)
 	return p
 }
 
 func (p *Proxy) Set(upstreams []string) {
-	desiredUpstreams := map[string]bool{}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	existingUpstreams := map[string]*Upstream{}
+	for _, u := range p.upstreams {
+		existingUpstreams[u.addr] = u
+	}
+
+	desiredUpstreams := []*Upstream{}
+
+	for _, addr := range upstreams {
+		u := existingUpstreams[addr]
+		if u == nil && p.draining[addr] != nil {
+			log.Printf("Restoring route %s -> %s\n", p.address, addr)
+			u = p.draining[addr]
+			u.cancelDrain()
+			delete(p.draining, addr)
+		} else if u == nil {
+			log.Printf("Adding route %s -> %s\n", p.address, addr)
+			u = newUpstream(addr)
+			go u.healthCheck(p)
+		}
+
+		delete(existingUpstreams, addr)
+		desiredUpstreams = append(desiredUpstreams, u)
+	}
+
+	for addr, u := range existingUpstreams {
+		log.Printf("Removing route %s -> %s\n", p.address, addr)
+		p.draining[addr] = u
+		go p.drain(u, u.startDrain())
+	}
+
+	p.upstreams = desiredUpstreams
+}
+
+// drain waits for the connections to a removed upstream to finish, closing any
+// that remain after DrainTimeout. It stops early if the upstream is restored.
+func (p *Proxy) drain(u *Upstream, cancelCh chan bool) {
+	deadline := time.After(p.DrainTimeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for u.ActiveConnections() > 0 {
+		select {
+		case <-cancelCh:
+			return
+		case <-ticker.C:
+		case <-deadline:
+			u.closeConns(p.DrainTimeout / 10)
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-	for _, upstream := range upstreams {
-		if p.upstreams[upstream] == 0 {
-			log.Printf("Adding route %s -> %s\n", p.address, upstream)
-			p.upstreams[upstream] = p.proxy.AddRoute(p.address, tcpproxy.To(upstream))
+	select {
+	case <-cancelCh:
+		return
+	default:
+	}
+
+	delete(p.draining, u.addr)
+	u.stop()
+}
+
+// pick uses the balancer to choose a healthy upstream that has not already been
+// tried, or returns nil if there are none.
+func (p *Proxy) pick(tried map[*Upstream]bool) *Upstream {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	candidates := []*Upstream{}
+	for _, u := range p.upstreams {
+		if !tried[u] && u.Healthy() {
+			candidates = append(candidates, u)
 		}
+	}
 
-		desiredUpstreams[upstream] = true
+	if len(candidates) == 0 {
+		return nil
 	}
 
-	for upstream, routeId := range p.upstreams {
-		if ! desiredUpstreams[upstream] {
-			log.Printf("Removing route %s -> %s\n", p.address, upstream)
-			p.proxy.RemoveRoute(p.address, routeId)
-			delete(p.upstreams, upstream)
+	return p.Balancer.Pick(candidates)
+}
+
+// HandleConn implements tcpproxy.Target, proxying the connection to a healthy
+// upstream and failing over to the next healthy upstream if it cannot be dialed.
+func (p *Proxy) HandleConn(src net.Conn) {
+	tried := map[*Upstream]bool{}
+
+	// Wrapping the connection hides it from DialProxy, so set keep alives here.
+	if c, ok := tcpproxy.UnderlyingConn(src).(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+		c.SetKeepAlivePeriod(time.Minute)
+	}
+
+	for {
+		u := p.pick(tried)
+		if u == nil {
+			log.Printf("no healthy upstreams for %s, closing connection from %s\n", p.address, src.RemoteAddr())
+			src.Close()
+			return
+		}
+
+		tried[u] = true
+
+		dialed := true
+		dp := &tcpproxy.DialProxy{
+			Addr:        u.addr,
+			DialTimeout: p.HealthCheckTimeout,
+			OnDialError: func(src net.Conn, err error) {
+				log.Printf("error dialing upstream %s: %s\n", u.addr, err)
+				atomic.AddUint64(&u.dialErrors, 1)
+				u.setHealthy(false, 1)
+				dialed = false
+			},
+		}
+
+		conn := &countingConn{src, u}
+		u.addConn(conn)
+		dp.HandleConn(conn)
+		u.removeConn(conn)
+
+		if dialed {
+			return
 		}
 	}
 }
 
 func (p *Proxy) Run() error {
-	log.Println("Starting API server load balancer on:", p.address)
+	log.Printf("Starting %s load balancer on: %s\n", p.Name, p.address)
 	return p.proxy.Start()
 }
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoServer accepts connections and echoes what it receives until closed.
+func echoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return listener
+}
+
+// closedAddr returns an address that refuses connections.
+func closedAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// healthyUpstream returns an upstream that is healthy without health checks.
+func healthyUpstream(addr string) *Upstream {
+	u := newUpstream(addr)
+	u.healthy = true
+	return u
+}
+
+func TestFailover(t *testing.T) {
+	listener := echoServer(t)
+	defer listener.Close()
+
+	dead := healthyUpstream(closedAddr(t))
+	live := healthyUpstream(listener.Addr().String())
+
+	p := NewProxy("127.0.0.1:0")
+	p.FailureThreshold = 3
+	// Round-robin tries the dead upstream first.
+	p.upstreams = []*Upstream{dead, live}
+
+	client, src := net.Pipe()
+	done := make(chan bool)
+	go func() {
+		p.HandleConn(src)
+		close(done)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "ping" {
+		t.Errorf("expected the connection to be proxied to the live upstream, got %q", buf)
+	}
+
+	// A dial error marks the upstream unhealthy right away, regardless of the
+	// failure threshold.
+	if dead.Healthy() {
+		t.Error("expected the upstream that could not be dialed to be unhealthy")
+	}
+
+	if errors := atomic.LoadUint64(&dead.dialErrors); errors != 1 {
+		t.Errorf("expected one dial error, got %d", errors)
+	}
+
+	client.Close()
+	<-done
+}
+
+func TestNoHealthyUpstreams(t *testing.T) {
+	dead := healthyUpstream(closedAddr(t))
+
+	p := NewProxy("127.0.0.1:0")
+	p.upstreams = []*Upstream{dead, newUpstream(closedAddr(t))}
+
+	client, src := net.Pipe()
+	go p.HandleConn(src)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the connection to be closed, got: %v", err)
+	}
+}
+
+// drainedConn records when it is closed and removes itself from its upstream, as
+// HandleConn does once a proxied connection ends.
+type drainedConn struct {
+	net.Conn
+	upstream *Upstream
+	closed   chan time.Time
+}
+
+func (c *drainedConn) Close() error {
+	c.upstream.removeConn(c)
+	c.closed <- time.Now()
+	return c.Conn.Close()
+}
+
+func TestDrain(t *testing.T) {
+	p := NewProxy("127.0.0.1:0")
+	p.DrainTimeout = 500 * time.Millisecond
+
+	u := newUpstream("10.0.0.1:6443")
+	closed := make(chan time.Time, 3)
+	for i := 0; i < 3; i++ {
+		conn, _ := net.Pipe()
+		u.addConn(&drainedConn{conn, u, closed})
+	}
+
+	start := time.Now()
+	p.draining[u.addr] = u
+	done := make(chan bool)
+	go func() {
+		p.drain(u, u.startDrain())
+		close(done)
+	}()
+
+	closeTimes := []time.Time{}
+	for i := 0; i < 3; i++ {
+		select {
+		case closedAt := <-closed:
+			closeTimes = append(closeTimes, closedAt)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the connections to be closed")
+		}
+	}
+
+	if waited := closeTimes[0].Sub(start); waited < p.DrainTimeout {
+		t.Errorf("expected connections to be left open for %s, the first was closed after %s", p.DrainTimeout, waited)
+	}
+
+	// The connections are closed one at a time over a tenth of the drain timeout.
+	interval := p.DrainTimeout / 10 / 3
+	for i := 1; i < len(closeTimes); i++ {
+		if gap := closeTimes[i].Sub(closeTimes[i-1]); gap < interval {
+			t.Errorf("expected connections to be closed at least %s apart, got %s", interval, gap)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for draining to finish")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.draining[u.addr] != nil {
+		t.Error("expected the drained upstream to be removed")
+	}
+}
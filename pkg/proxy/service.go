@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/serf/serf"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Service is a proxied service whose upstreams are the serf members with matching
+// tags.
+type Service struct {
+	// Name of the service, e.g. apiserver.
+	Name string `json:"name"`
+	// Address to listen on, e.g. 127.0.13.37:6444.
+	Listen string `json:"listen"`
+	// Port of the service on each upstream.
+	Port int `json:"port"`
+	// Tags that a serf member must have to be an upstream, e.g. role: master.
+	Tags map[string]string `json:"tags,omitempty"`
+	// If set, the path to health check over TLS, otherwise a TCP check is used.
+	HealthCheckPath string `json:"healthCheckPath,omitempty"`
+	// Load balancing strategy, defaults to round-robin.
+	Balancer string `json:"balancer,omitempty"`
+	// How long to wait for connections to a removed upstream to finish, e.g. 5m.
+	DrainTimeout string `json:"drainTimeout,omitempty"`
+}
+
+// ServiceConfig is the file format for declaring proxied services.
+type ServiceConfig struct {
+	Services []Service `json:"services"`
+}
+
+// LoadServices reads proxied services from a YAML or JSON file.
+func LoadServices(path string) ([]Service, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := ServiceConfig{}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid proxy configuration: %s", err)
+	}
+
+	return config.Services, nil
+}
+
+// Matches returns true if the member should be an upstream for the service.
+func (s Service) Matches(member serf.Member) bool {
+	if member.Status != serf.StatusAlive {
+		return false
+	}
+
+	for key, value := range s.Tags {
+		if member.Tags[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Manager runs a proxy for each service.
+type Manager struct {
+	mutex     sync.Mutex
+	localAddr string
+	services  []Service
+	proxies   map[string]*Proxy
+}
+
+// NewManager creates a proxy manager. localAddr is the address of this node, used
+// for local-first load balancing.
+func NewManager(localAddr string) *Manager {
+	return &Manager{
+		localAddr: localAddr,
+		proxies:   map[string]*Proxy{},
+	}
+}
+
+// Add creates a proxy for the service, it must be called before Run.
+func (m *Manager) Add(service Service) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if service.Name == "" {
+		return fmt.Errorf("proxied service must have a name")
+	}
+
+	if m.proxies[service.Name] != nil {
+		return fmt.Errorf("proxied service %s is defined more than once", service.Name)
+	}
+
+	if _, _, err := net.SplitHostPort(service.Listen); err != nil {
+		return fmt.Errorf("invalid listen address for service %s: %s", service.Name, err)
+	}
+
+	if service.Port <= 0 || service.Port > 65535 {
+		return fmt.Errorf("invalid port for service %s: %d", service.Name, service.Port)
+	}
+
+	p := NewProxy(service.Listen)
+	p.Name = service.Name
+	p.HealthCheckPath = service.HealthCheckPath
+
+	if service.Balancer != "" {
+		balancer, err := NewBalancer(service.Balancer, m.localAddr)
+		if err != nil {
+			return fmt.Errorf("invalid balancer for service %s: %s", service.Name, err)
+		}
+		p.Balancer = balancer
+	}
+
+	if service.DrainTimeout != "" {
+		drainTimeout, err := time.ParseDuration(service.DrainTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid drain timeout for service %s: %s", service.Name, err)
+		}
+		p.DrainTimeout = drainTimeout
+	}
+
+	m.services = append(m.services, service)
+	m.proxies[service.Name] = p
+	return nil
+}
+
+// Proxy returns the proxy for the named service.
+func (m *Manager) Proxy(name string) *Proxy {
+	return m.proxies[name]
+}
+
+// Run starts all of the proxies.
+func (m *Manager) Run() error {
+	for _, service := range m.services {
+		if err := m.proxies[service.Name].Run(); err != nil {
+			return fmt.Errorf("error starting proxy for %s: %s", service.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Update sets the upstreams of each proxy from the current serf members.
+func (m *Manager) Update(members []serf.Member) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, service := range m.services {
+		upstreams := []string{}
+
+		for _, member := range members {
+			if service.Matches(member) {
+				upstreams = append(upstreams, net.JoinHostPort(member.Addr.String(), strconv.Itoa(service.Port)))
+			}
+		}
+
+		sort.Strings(upstreams)
+		m.proxies[service.Name].Set(upstreams)
+	}
+}
+
+// Status returns the status of every proxy.
+func (m *Manager) Status() []ProxyStatus {
+	statuses := []ProxyStatus{}
+
+	for _, service := range m.services {
+		statuses = append(statuses, m.proxies[service.Name].Status())
+	}
+
+	return statuses
+}
+
+// StatusHandler serves the status of every proxy as JSON.
+func (m *Manager) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Status())
+	})
+}
+
+// Collectors returns the Prometheus collectors for every proxy.
+func (m *Manager) Collectors() []prometheus.Collector {
+	collectors := []prometheus.Collector{}
+
+	for _, service := range m.services {
+		collectors = append(collectors, m.proxies[service.Name].Collector())
+	}
+
+	return collectors
+}
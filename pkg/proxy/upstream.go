@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream is a backend that the proxy can route connections to.
+type Upstream struct {
+	sync.Mutex
+	addr     string
+	healthy  bool
+	failures int
+	conns    map[net.Conn]bool
+	stopCh   chan bool
+	cancelCh chan bool
+
+	bytesSent     uint64
+	bytesReceived uint64
+	dialErrors    uint64
+}
+
+func newUpstream(addr string) *Upstream {
+	return &Upstream{
+		addr:   addr,
+		conns:  map[net.Conn]bool{},
+		stopCh: make(chan bool),
+	}
+}
+
+// Addr returns the address of the upstream.
+func (u *Upstream) Addr() string {
+	return u.addr
+}
+
+func (u *Upstream) Healthy() bool {
+	u.Lock()
+	defer u.Unlock()
+	return u.healthy
+}
+
+// ActiveConnections returns the number of connections currently proxied to the
+// upstream.
+func (u *Upstream) ActiveConnections() int {
+	u.Lock()
+	defer u.Unlock()
+	return len(u.conns)
+}
+
+func (u *Upstream) addConn(conn net.Conn) {
+	u.Lock()
+	defer u.Unlock()
+	u.conns[conn] = true
+}
+
+func (u *Upstream) removeConn(conn net.Conn) {
+	u.Lock()
+	defer u.Unlock()
+	delete(u.conns, conn)
+}
+
+// Draining returns true if the upstream has been removed and is waiting for its
+// connections to finish.
+func (u *Upstream) Draining() bool {
+	u.Lock()
+	defer u.Unlock()
+	return u.cancelCh != nil
+}
+
+// startDrain marks the upstream as draining and returns a channel that is closed
+// if draining is cancelled.
+func (u *Upstream) startDrain() chan bool {
+	u.Lock()
+	defer u.Unlock()
+	u.cancelCh = make(chan bool)
+	return u.cancelCh
+}
+
+func (u *Upstream) cancelDrain() {
+	u.Lock()
+	defer u.Unlock()
+	close(u.cancelCh)
+	u.cancelCh = nil
+}
+
+// closeConns closes the upstream's connections one at a time, spread over
+// interval, so that clients do not all reconnect at once.
+func (u *Upstream) closeConns(interval time.Duration) {
+	u.Lock()
+	conns := []net.Conn{}
+	for conn := range u.conns {
+		conns = append(conns, conn)
+	}
+	u.Unlock()
+
+	if len(conns) == 0 {
+		return
+	}
+
+	log.Printf("closing %d connections to upstream %s\n", len(conns), u.addr)
+
+	for _, conn := range conns {
+		conn.Close()
+		time.Sleep(interval / time.Duration(len(conns)))
+	}
+}
+
+// setHealthy records the result of a health check. An upstream is marked healthy
+// after a single successful check, and unhealthy after failureThreshold
+// consecutive failed checks.
+func (u *Upstream) setHealthy(healthy bool, failureThreshold int) {
+	u.Lock()
+	defer u.Unlock()
+
+	if healthy {
+		if !u.healthy {
+			log.Printf("upstream %s is healthy\n", u.addr)
+		}
+
+		u.failures = 0
+		u.healthy = true
+		return
+	}
+
+	u.failures++
+	if u.healthy && u.failures >= failureThreshold {
+		log.Printf("upstream %s is unhealthy\n", u.addr)
+		u.healthy = false
+	}
+}
+
+// check connects to the upstream or, if healthCheckPath is set, requests it over
+// TLS. The upstream is considered healthy if the connection succeeds and the
+// request returns 200, or 401 or 403 if anonymous requests are not permitted.
+func (u *Upstream) check(healthCheckPath string, timeout time.Duration) error {
+	if healthCheckPath == "" {
+		conn, err := net.DialTimeout("tcp", u.addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				// Upstreams are addressed by IP and civitas has no CA to verify
+				// them against, this check only determines liveness.
+				InsecureSkipVerify: true,
+			},
+			DisableKeepAlives: true,
+		},
+	}
+
+	resp, err := client.Get(fmt.Sprintf("https://%s%s", u.addr, healthCheckPath))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusUnauthorized, http.StatusForbidden:
+		return nil
+	default:
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+}
+
+// healthCheck checks the upstream every interval until it is stopped.
+func (u *Upstream) healthCheck(p *Proxy) {
+	ticker := time.NewTicker(p.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		err := u.check(p.HealthCheckPath, p.HealthCheckTimeout)
+		if err != nil && u.Healthy() {
+			log.Printf("health check for upstream %s failed: %s\n", u.addr, err)
+		}
+		u.setHealthy(err == nil, p.FailureThreshold)
+
+		select {
+		case <-u.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// countingConn wraps a client connection, counting the bytes proxied to and from
+// an upstream.
+type countingConn struct {
+	net.Conn
+	upstream *Upstream
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.upstream.bytesSent, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.upstream.bytesReceived, uint64(n))
+	return n, err
+}
+
+func (u *Upstream) stop() {
+	close(u.stopCh)
+}
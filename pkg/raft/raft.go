@@ -2,7 +2,6 @@ package raft
 
 import (
 	"errors"
-	"fmt"
 	"github.com/hashicorp/go-msgpack/codec"
 	"github.com/hashicorp/raft"
 	"io"
@@ -72,23 +71,30 @@ func (m *Snapshot) Release() {}
 type Raft struct {
 	Name       string
 	ListenAddr string
-	raft       *raft.Raft
-	fsm        *FSM
-	notifyCh   chan bool
-	added      map[string]bool
+	// The address peers use to reach this node, defaults to ListenAddr.
+	AdvertiseAddr string
+	raft          *raft.Raft
+	fsm           *FSM
+	notifyCh      chan bool
+	added         map[string]bool
 }
 
-func NewRaft(name, listenAddr string, port int) (*Raft, error) {
+func NewRaft(name, listenAddr, advertiseAddr string) (*Raft, error) {
 	raft := &Raft{
-		Name:       name,
-		ListenAddr: fmt.Sprintf("%s:%d", listenAddr, port),
+		Name:          name,
+		ListenAddr:    listenAddr,
+		AdvertiseAddr: advertiseAddr,
 	}
 
 	return raft, raft.Start()
 }
 
 func (r *Raft) Start() error {
-	addr, err := net.ResolveTCPAddr("tcp", r.ListenAddr)
+	if r.AdvertiseAddr == "" {
+		r.AdvertiseAddr = r.ListenAddr
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", r.AdvertiseAddr)
 	if err != nil {
 		return err
 	}
@@ -145,7 +151,7 @@ func (r *Raft) Bootstrap() error {
 		Servers: []raft.Server{
 			{
 				ID:      raft.ServerID(r.Name),
-				Address: raft.ServerAddress(r.ListenAddr),
+				Address: raft.ServerAddress(r.AdvertiseAddr),
 			},
 		},
 	}).Error()
@@ -161,11 +167,11 @@ func (r *Raft) Bootstrap() error {
 	return nil
 }
 
-func (r *Raft) AddNode(name string, addr net.IP, port uint16) error {
-	memberAddr := raft.ServerAddress(fmt.Sprintf("%s:%d", addr, port))
+func (r *Raft) AddNode(name, addr string) error {
+	memberAddr := raft.ServerAddress(addr)
 	err := r.raft.AddVoter(raft.ServerID(name), memberAddr, 0, 5*time.Second).Error()
 	if r.added[name] == false {
-		log.Printf("added member to raft %s (%s:%d)\n", name, addr, port)
+		log.Printf("added member to raft %s (%s)\n", name, memberAddr)
 	}
 	r.added[name] = true
 	return err
@@ -178,3 +184,72 @@ func (r *Raft) Apply(data []byte) error {
 func (r *Raft) Leader() bool {
 	return r.raft.State() == raft.Leader
 }
+
+// RemoveNode removes a member from the raft cluster, it must be called on the
+// leader.
+func (r *Raft) RemoveNode(name string) error {
+	err := r.raft.RemoveServer(raft.ServerID(name), 0, 5*time.Second).Error()
+	if err == nil && r.added[name] {
+		log.Printf("removed member from raft %s\n", name)
+	}
+	delete(r.added, name)
+	return err
+}
+
+// Shutdown stops raft on this node.
+func (r *Raft) Shutdown() error {
+	return r.raft.Shutdown().Error()
+}
+
+// Stats returns raft's statistics, such as its state, term and commit index.
+func (r *Raft) Stats() map[string]string {
+	return r.raft.Stats()
+}
+
+// Peer is a member of the raft configuration.
+type Peer struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+}
+
+// Status describes the raft state of this node.
+type Status struct {
+	State string `json:"state"`
+	// The ID and address of the current leader, empty if there is none.
+	Leader        string            `json:"leader"`
+	LeaderAddress string            `json:"leader_address"`
+	Bootstrapped  bool              `json:"bootstrapped"`
+	Peers         []Peer            `json:"peers"`
+	Stats         map[string]string `json:"stats"`
+}
+
+// Status returns the raft state, leader and peers of this node.
+func (r *Raft) Status() (Status, error) {
+	status := Status{
+		State:         r.raft.State().String(),
+		LeaderAddress: string(r.raft.Leader()),
+		Bootstrapped:  r.Bootstrapped(),
+		Peers:         []Peer{},
+		Stats:         r.Stats(),
+	}
+
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return status, err
+	}
+
+	for _, server := range future.Configuration().Servers {
+		if string(server.Address) == status.LeaderAddress && status.LeaderAddress != "" {
+			status.Leader = string(server.ID)
+		}
+
+		status.Peers = append(status.Peers, Peer{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+		})
+	}
+
+	return status, nil
+}
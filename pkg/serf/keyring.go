@@ -0,0 +1,78 @@
+package serf
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// loadKeyring returns the keyring to encrypt gossip with: the keys persisted to
+// KeyringFile, which take precedence so that rotated keys survive a restart, or
+// EncryptKey. Gossip is not encrypted if there is neither.
+func (s *Serf) loadKeyring() (*memberlist.Keyring, error) {
+	if s.KeyringFile != "" {
+		keys, err := readKeyringFile(s.KeyringFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) > 0 {
+			if len(s.EncryptKey) > 0 {
+				log.Println("using the encryption keys in", s.KeyringFile, "instead of the configured key")
+			}
+
+			return memberlist.NewKeyring(keys, keys[0])
+		}
+	}
+
+	if len(s.EncryptKey) == 0 {
+		return nil, nil
+	}
+
+	return memberlist.NewKeyring(nil, s.EncryptKey)
+}
+
+// readKeyringFile reads the base64 encoded keys serf writes to a keyring file,
+// the primary key first.
+func readKeyringFile(path string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	encodedKeys := []string{}
+	if err := json.Unmarshal(data, &encodedKeys); err != nil {
+		return nil, fmt.Errorf("invalid keyring file %s: %s", path, err)
+	}
+
+	keys := [][]byte{}
+	for _, encoded := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in keyring file %s: %s", path, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// KeyManager returns the manager used to list and rotate the encryption keys of
+// every member.
+func (s *Serf) KeyManager() *serf.KeyManager {
+	return s.serf.KeyManager()
+}
+
+// RemoveFailedNode forcibly removes a failed member instead of waiting for it to
+// be reaped.
+func (s *Serf) RemoveFailedNode(name string) error {
+	return s.serf.RemoveFailedNode(name)
+}
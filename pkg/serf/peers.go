@@ -0,0 +1,150 @@
+package serf
+
+import (
+	"github.com/hashicorp/serf/serf"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// The delay before retrying a peer that could not be joined, doubled on each
+	// consecutive failure up to maxJoinBackoff.
+	minJoinBackoff = 2 * time.Second
+	maxJoinBackoff = 5 * time.Minute
+	// Peers that keep failing are forgotten if they have not been discovered or
+	// joined for this long.
+	peerStaleTimeout = 10 * time.Minute
+)
+
+// peer is a candidate address to join the cluster through.
+type peer struct {
+	// The last time the address was discovered or successfully joined.
+	lastSeen time.Time
+	// The number of consecutive failed joins.
+	failures int
+	// When the address may next be joined.
+	nextAttempt time.Time
+}
+
+// AddNode adds an address to join the cluster through. Adding a known address
+// marks it as recently seen, so that it is not pruned.
+func (s *Serf) AddNode(addr string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if p, ok := s.peers[addr]; ok {
+		p.lastSeen = time.Now()
+		return
+	}
+
+	s.peers[addr] = &peer{
+		lastSeen: time.Now(),
+	}
+}
+
+// NeedsPeers returns true if fewer members than expected are alive, so that
+// more peers should be discovered and joined.
+func (s *Serf) NeedsPeers() bool {
+	alive := 0
+	for _, member := range s.serf.Members() {
+		if member.Status == serf.StatusAlive {
+			alive++
+		}
+	}
+
+	return alive <= 1 || alive < s.ExpectedMembers
+}
+
+// JoinNodes adds addresses to join the cluster through and joins them right
+// away, even if the cluster already has the expected number of members.
+func (s *Serf) JoinNodes(addrs []string) {
+	for _, addr := range addrs {
+		s.AddNode(addr)
+
+		if _, err := s.serf.Join([]string{addr}, false); err != nil {
+			log.Printf("could not join peer %s: %s\n", addr, err)
+		}
+	}
+}
+
+// Join periodically joins known peers while the cluster has fewer members than
+// expected. Peers that fail are retried with an exponential backoff and are
+// forgotten once they are stale.
+func (s *Serf) Join() {
+	for {
+		if s.NeedsPeers() {
+			s.joinPeers()
+		}
+
+		time.Sleep(minJoinBackoff)
+	}
+}
+
+// joinPeers attempts to join each peer that is due to be retried and is not
+// already a member.
+func (s *Serf) joinPeers() {
+	members := map[string]bool{}
+	for _, member := range s.serf.Members() {
+		if member.Status == serf.StatusAlive {
+			members[net.JoinHostPort(member.Addr.String(), strconv.Itoa(int(member.Port)))] = true
+		}
+	}
+
+	now := time.Now()
+
+	s.mutex.Lock()
+	due := []string{}
+	for addr, p := range s.peers {
+		if members[addr] {
+			p.lastSeen = now
+			p.failures = 0
+			continue
+		}
+
+		if p.failures > 0 && now.Sub(p.lastSeen) > peerStaleTimeout {
+			log.Println("forgetting stale peer:", addr)
+			delete(s.peers, addr)
+			continue
+		}
+
+		if !now.Before(p.nextAttempt) {
+			due = append(due, addr)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, addr := range due {
+		_, err := s.serf.Join([]string{addr}, false)
+
+		s.mutex.Lock()
+		if p, ok := s.peers[addr]; ok {
+			if err != nil {
+				p.failures++
+				p.nextAttempt = time.Now().Add(joinBackoff(p.failures))
+				log.Printf("could not join peer %s (attempt %d): %s\n", addr, p.failures, err)
+			} else {
+				p.failures = 0
+				p.lastSeen = time.Now()
+				p.nextAttempt = time.Now().Add(minJoinBackoff)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// joinBackoff returns the delay before retrying a peer after failures
+// consecutive failed joins.
+func joinBackoff(failures int) time.Duration {
+	backoff := minJoinBackoff
+	for i := 1; i < failures && backoff < maxJoinBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxJoinBackoff {
+		return maxJoinBackoff
+	}
+
+	return backoff
+}
@@ -1,29 +1,69 @@
 package serf
 
 import (
+	"fmt"
+	"github.com/hashicorp/memberlist"
 	"github.com/hashicorp/serf/serf"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// Gossip profiles with memberlist timings tuned for different networks.
+const (
+	// ProfileLAN is for nodes on the same local network.
+	ProfileLAN = "lan"
+	// ProfileWAN tolerates the higher latency and packet loss between clouds or
+	// datacenters.
+	ProfileWAN = "wan"
+	// ProfileLocal is for nodes on the same host, e.g. for testing.
+	ProfileLocal = "local"
+)
+
 type Serf struct {
-	Name         string
-	Addr         string
-	Port         int
+	Name string
+	Addr string
+	Port int
+	// Called when members join.
 	JoinCallback func(serf.MemberEvent)
-	bootstrapAddrs []string
-	events       chan serf.Event
-	serf         *serf.Serf
+	// Called when members leave, fail, are updated or are reaped, but not when
+	// they join.
+	MemberCallback func(serf.MemberEvent)
+	// Called with each query sent to this node, it must respond before the query
+	// times out.
+	QueryCallback func(*serf.Query)
+	// The address and port to listen on, default to Addr and Port.
+	BindAddr string
+	BindPort int
+	// The gossip profile, defaults to ProfileLAN.
+	Profile string
+	// Tags advertised when serf starts.
+	Tags map[string]string
+	// File to persist known members to, so that they can be rejoined on restart.
+	SnapshotPath string
+	// The key to encrypt gossip with, 16, 24 or 32 bytes.
+	EncryptKey []byte
+	// File to persist the encryption keys to when they are rotated.
+	KeyringFile string
+	// The number of members expected to be alive, discovery and joining stop
+	// once this many members are alive.
+	ExpectedMembers int
+	peers           map[string]*peer
+	mutex           sync.Mutex
+	events          chan serf.Event
+	serf            *serf.Serf
 }
 
 func NewSerf(name string, addr string, port int) *Serf {
 	return &Serf{
-		Name: name,
-		Addr: addr,
-		Port: port,
-		bootstrapAddrs: []string{},
+		Name:  name,
+		Addr:  addr,
+		Port:  port,
+		peers: map[string]*peer{},
 	}
 }
 
@@ -31,13 +71,57 @@ func (s *Serf) Start() (err error) {
 	s.events = make(chan serf.Event)
 
 	serfConfig := serf.DefaultConfig()
-	serfConfig.MemberlistConfig.BindPort = s.Port
-	serfConfig.MemberlistConfig.BindAddr = s.Addr
+
+	switch s.Profile {
+	case "", ProfileLAN:
+		serfConfig.MemberlistConfig = memberlist.DefaultLANConfig()
+	case ProfileWAN:
+		serfConfig.MemberlistConfig = memberlist.DefaultWANConfig()
+	case ProfileLocal:
+		serfConfig.MemberlistConfig = memberlist.DefaultLocalConfig()
+	default:
+		return fmt.Errorf("invalid gossip profile %s, must be %s, %s or %s", s.Profile, ProfileLAN, ProfileWAN, ProfileLocal)
+	}
+	if s.BindAddr == "" {
+		s.BindAddr = s.Addr
+	}
+
+	if s.BindPort == 0 {
+		s.BindPort = s.Port
+	}
+
+	serfConfig.MemberlistConfig.BindPort = s.BindPort
+	serfConfig.MemberlistConfig.BindAddr = s.BindAddr
 	serfConfig.MemberlistConfig.AdvertisePort = s.Port
 	serfConfig.MemberlistConfig.AdvertiseAddr = s.Addr
 	serfConfig.NodeName = s.Name
+	serfConfig.Tags = s.Tags
 	serfConfig.EventCh = s.events
 
+	if s.SnapshotPath != "" {
+		serfConfig.SnapshotPath = s.SnapshotPath
+
+		peers, err := previousPeers(s.SnapshotPath, s.Name)
+		if err != nil {
+			log.Println("could not read serf snapshot:", err)
+		} else if len(peers) > 0 {
+			log.Println("rejoining previously known peers:", peers)
+			for _, addr := range peers {
+				s.AddNode(addr)
+			}
+		}
+	}
+
+	keyring, err := s.loadKeyring()
+	if err != nil {
+		return err
+	}
+
+	if keyring != nil {
+		serfConfig.MemberlistConfig.Keyring = keyring
+		serfConfig.KeyringFile = s.KeyringFile
+	}
+
 	if os.Getenv("DEBUG") != "1" {
 		serfConfig.LogOutput = ioutil.Discard
 		serfConfig.MemberlistConfig.LogOutput = ioutil.Discard
@@ -45,15 +129,21 @@ func (s *Serf) Start() (err error) {
 
 	s.serf, err = serf.Create(serfConfig)
 
-	log.Printf("serf listening at: %s:%d\n", s.Addr, s.Port)
+	log.Printf("serf listening at: %s\n", net.JoinHostPort(s.BindAddr, strconv.Itoa(s.BindPort)))
 
 	go func() {
 		for event := range s.events {
 			switch event.EventType() {
+			case serf.EventQuery:
+				if s.QueryCallback != nil {
+					s.QueryCallback(event.(*serf.Query))
+				}
 			case serf.EventMemberJoin:
 				s.JoinCallback(event.(serf.MemberEvent))
-			default:
-				continue
+			case serf.EventMemberLeave, serf.EventMemberFailed, serf.EventMemberUpdate, serf.EventMemberReap:
+				if s.MemberCallback != nil {
+					s.MemberCallback(event.(serf.MemberEvent))
+				}
 			}
 		}
 	}()
@@ -61,20 +151,34 @@ func (s *Serf) Start() (err error) {
 	return
 }
 
-func (s *Serf) AddNode(addr string) {
-	s.bootstrapAddrs = append(s.bootstrapAddrs, addr)
+func (s *Serf) Members() []serf.Member {
+	return s.serf.Members()
 }
 
-func (s *Serf) Join() {
-	for {
-		if _, err := s.serf.Join(s.bootstrapAddrs, false); err != nil {
-			log.Println(err)
-		}
+// LocalMember returns the serf member for this node.
+func (s *Serf) LocalMember() serf.Member {
+	return s.serf.LocalMember()
+}
 
-		time.Sleep(2 * time.Second)
-	}
+// SetTags replaces the tags advertised for this node.
+func (s *Serf) SetTags(tags map[string]string) error {
+	return s.serf.SetTags(tags)
 }
 
-func (s *Serf) Members() []serf.Member {
-	return s.serf.Members()
+// Query sends a query to the named members and returns their responses. Unlike
+// user events, queries are not replayed to members that join later.
+func (s *Serf) Query(name string, payload []byte, nodes []string, timeout time.Duration) (*serf.QueryResponse, error) {
+	return s.serf.Query(name, payload, &serf.QueryParam{
+		FilterNodes: nodes,
+		Timeout:     timeout,
+	})
+}
+
+// Leave gracefully leaves the cluster and shuts down serf.
+func (s *Serf) Leave() error {
+	if err := s.serf.Leave(); err != nil {
+		return err
+	}
+
+	return s.serf.Shutdown()
 }
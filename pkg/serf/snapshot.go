@@ -0,0 +1,52 @@
+package serf
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// previousPeers returns the addresses of the members that were alive when the
+// serf snapshot at path was last written, excluding this node. It returns no
+// peers if the snapshot does not exist or this node left the cluster.
+func previousPeers(path, self string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	alive := map[string]string{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "alive: "):
+			info := strings.TrimPrefix(line, "alive: ")
+			if i := strings.LastIndex(info, " "); i != -1 {
+				alive[info[:i]] = info[i+1:]
+			}
+		case strings.HasPrefix(line, "not-alive: "):
+			delete(alive, strings.TrimPrefix(line, "not-alive: "))
+		case line == "leave":
+			alive = map[string]string{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	peers := []string{}
+	for name, addr := range alive {
+		if name != self {
+			peers = append(peers, addr)
+		}
+	}
+
+	return peers, nil
+}
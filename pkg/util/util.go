@@ -1,25 +1,127 @@
 package util
 
 import (
+	"fmt"
 	"net"
+	"strconv"
+	"strings"
 )
 
-func GetIPForInterface(iface string) (string, error) {
-	ifaces, err := net.Interfaces()
+// Address families that can be preferred when picking an interface address.
+const (
+	IPv4 = "ipv4"
+	IPv6 = "ipv6"
+)
+
+// Addresses used to find the default route for each family. They are never sent
+// any packets.
+var defaultRouteProbes = map[string]string{
+	IPv4: "203.0.113.1:9",
+	IPv6: "[2001:db8::1]:9",
+}
+
+// AddressSelector picks the address a node advertises. With an interface or CIDR
+// it picks an address of that interface or in that CIDR, otherwise the address of
+// the default route. Loopback and link-local addresses are never picked.
+type AddressSelector struct {
+	// The interface to pick an address from.
+	Interface string
+	// Only pick addresses in this CIDR, e.g. 10.0.0.0/8.
+	CIDR string
+	// The address family to prefer, ipv4 or ipv6. The other family is used if
+	// there is no address of the preferred family.
+	Family string
+}
+
+// candidate is a usable address and the interface it is assigned to.
+type candidate struct {
+	iface string
+	ip    net.IP
+}
+
+// Select returns the address to advertise.
+func (s *AddressSelector) Select() (string, error) {
+	if s.Family != IPv4 && s.Family != IPv6 {
+		return "", fmt.Errorf("invalid address family %s, must be %s or %s", s.Family, IPv4, IPv6)
+	}
+
+	var cidr *net.IPNet
+	if s.CIDR != "" {
+		var err error
+		_, cidr, err = net.ParseCIDR(s.CIDR)
+		if err != nil {
+			return "", fmt.Errorf("invalid advertise CIDR %s: %s", s.CIDR, err)
+		}
+	}
+
+	candidates, err := s.candidates()
 	if err != nil {
 		return "", err
 	}
 
-	var ipStr string
+	if cidr != nil {
+		matching := []candidate{}
+		for _, c := range candidates {
+			if cidr.Contains(c.ip) {
+				matching = append(matching, c)
+			}
+		}
+
+		if len(matching) == 0 {
+			return "", fmt.Errorf("no address in %s found%s, addresses: %s", s.CIDR, s.where(), formatCandidates(candidates))
+		}
+
+		return s.prefer(matching).String(), nil
+	}
 
-	for _, i := range ifaces {
-		if i.Name != iface {
+	if s.Interface == "" {
+		for _, family := range s.families() {
+			if ip := defaultRouteIP(family); ip != nil {
+				for _, c := range candidates {
+					if c.ip.Equal(ip) {
+						return ip.String(), nil
+					}
+				}
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no usable address found%s", s.where())
+	}
+
+	return s.prefer(candidates).String(), nil
+}
+
+// candidates returns the usable addresses of the interface, or of every
+// interface that is up if no interface is set.
+func (s *AddressSelector) candidates() ([]candidate, error) {
+	var ifaces []net.Interface
+
+	if s.Interface != "" {
+		iface, err := net.InterfaceByName(s.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s not found: %s", s.Interface, err)
+		}
+		ifaces = []net.Interface{*iface}
+	} else {
+		var err error
+		ifaces, err = net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := []candidate{}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
 			continue
 		}
 
-		addrs, err := i.Addrs()
+		addrs, err := iface.Addrs()
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		for _, addr := range addrs {
@@ -31,13 +133,107 @@ func GetIPForInterface(iface string) (string, error) {
 				ip = v.IP
 			}
 
-			if ip.To4() == nil {
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
 				continue
 			}
 
-			ipStr = ip.String()
+			candidates = append(candidates, candidate{iface.Name, ip})
+		}
+	}
+
+	return candidates, nil
+}
+
+// families returns the address families in order of preference.
+func (s *AddressSelector) families() []string {
+	if s.Family == IPv6 {
+		return []string{IPv6, IPv4}
+	}
+
+	return []string{IPv4, IPv6}
+}
+
+// prefer returns the first candidate of the preferred family, or the first
+// candidate if there is none.
+func (s *AddressSelector) prefer(candidates []candidate) net.IP {
+	for _, c := range candidates {
+		if (c.ip.To4() != nil) == (s.Family == IPv4) {
+			return c.ip
 		}
 	}
 
-	return ipStr, nil
+	return candidates[0].ip
+}
+
+func (s *AddressSelector) where() string {
+	if s.Interface != "" {
+		return " on interface " + s.Interface
+	}
+
+	return " on any interface"
+}
+
+func formatCandidates(candidates []candidate) string {
+	if len(candidates) == 0 {
+		return "none"
+	}
+
+	addrs := []string{}
+	for _, c := range candidates {
+		addrs = append(addrs, fmt.Sprintf("%s (%s)", c.ip, c.iface))
+	}
+
+	return strings.Join(addrs, ", ")
+}
+
+// OtherFamilyAddress returns an address of the other family on the interface
+// that ip is assigned to, e.g. the IPv6 address of a dual-stack interface for its
+// IPv4 address. It returns nil if ip is not local or there is no such address.
+func OtherFamilyAddress(ip string) net.IP {
+	local := net.ParseIP(ip)
+	if local == nil {
+		return nil
+	}
+
+	candidates, err := (&AddressSelector{}).candidates()
+	if err != nil {
+		return nil
+	}
+
+	for _, c := range candidates {
+		if !c.ip.Equal(local) {
+			continue
+		}
+
+		for _, other := range candidates {
+			if other.iface == c.iface && (other.ip.To4() != nil) != (local.To4() != nil) {
+				return other.ip
+			}
+		}
+	}
+
+	return nil
+}
+
+// defaultRouteIP returns the source address of the default route for the
+// family, or nil if there is no default route.
+func defaultRouteIP(family string) net.IP {
+	conn, err := net.Dial("udp", defaultRouteProbes[family])
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// EnsurePort returns addr with the given port if it does not already have one.
+// Bare IPv6 addresses, with or without brackets, are bracketed.
+func EnsurePort(addr string, port int) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
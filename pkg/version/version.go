@@ -0,0 +1,6 @@
+package version
+
+// Version is the civitas version, set at build time with:
+//
+//	go build -ldflags "-X github.com/justinbarrick/civitas/pkg/version.Version=v0.1.0"
+var Version = "dev"
@@ -0,0 +1,66 @@
+package vip
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"time"
+)
+
+func htons(i uint16) uint16 {
+	return (i<<8)&0xff00 | i>>8
+}
+
+// gratuitousARP broadcasts ARP requests and replies for ip from the interface's
+// hardware address.
+func gratuitousARP(iface *net.Interface, ip net.IP) error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], broadcast)
+
+	for i := 0; i < 3; i++ {
+		for _, op := range []uint16{1, 2} {
+			if err := syscall.Sendto(fd, arpPacket(op, iface.HardwareAddr, broadcast, ip), 0, addr); err != nil {
+				return err
+			}
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return nil
+}
+
+// arpPacket builds an Ethernet frame containing an ARP packet where the sender and
+// target protocol addresses are both ip.
+func arpPacket(op uint16, src, dst net.HardwareAddr, ip net.IP) []byte {
+	frame := make([]byte, 0, 42)
+
+	// Ethernet header.
+	frame = append(frame, dst...)
+	frame = append(frame, src...)
+	frame = append(frame, 0x08, 0x06)
+
+	// ARP packet: Ethernet, IPv4, address lengths and operation.
+	frame = append(frame, 0x00, 0x01, 0x08, 0x00, 6, 4)
+	frame = append(frame, 0, 0)
+	binary.BigEndian.PutUint16(frame[len(frame)-2:], op)
+
+	frame = append(frame, src...)
+	frame = append(frame, ip...)
+	frame = append(frame, dst...)
+	frame = append(frame, ip...)
+
+	return frame
+}
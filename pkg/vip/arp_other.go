@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package vip
+
+import (
+	"errors"
+	"net"
+)
+
+func gratuitousARP(iface *net.Interface, ip net.IP) error {
+	return errors.New("gratuitous ARP is only supported on Linux")
+}
@@ -0,0 +1,109 @@
+package vip
+
+import (
+	"fmt"
+	"github.com/justinbarrick/civitas/pkg/executor"
+	"log"
+	"net"
+)
+
+// VIP is a floating virtual IP address that can be claimed by a node.
+type VIP struct {
+	Address   net.IP
+	Interface string
+	executor  executor.Executor
+}
+
+func NewVIP(address, iface string, executor executor.Executor) (*VIP, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid virtual IP: %s", address)
+	}
+
+	// Moving an IPv6 address needs unsolicited neighbor advertisements, which
+	// are not implemented.
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("invalid virtual IP %s: only IPv4 virtual IPs are supported", address)
+	}
+
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return nil, fmt.Errorf("invalid virtual IP interface %s: %s", iface, err)
+	}
+
+	return &VIP{
+		Address:   ip,
+		Interface: iface,
+		executor:  executor,
+	}, nil
+}
+
+// prefix returns the address with a host prefix length, e.g. 10.0.0.10/32.
+func (v *VIP) prefix() string {
+	return fmt.Sprintf("%s/32", v.Address)
+}
+
+// Held returns true if the address is assigned to the interface.
+func (v *VIP) Held() (bool, error) {
+	iface, err := net.InterfaceByName(v.Interface)
+	if err != nil {
+		return false, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false, err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(v.Address) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Acquire assigns the address to the interface and announces it to the network.
+func (v *VIP) Acquire() error {
+	held, err := v.Held()
+	if err != nil {
+		return err
+	}
+
+	if !held {
+		log.Printf("acquiring virtual IP %s on %s\n", v.Address, v.Interface)
+
+		if err := v.executor.Run("ip", "addr", "add", v.prefix(), "dev", v.Interface); err != nil {
+			return err
+		}
+
+		// Under a dry run the address is not actually assigned.
+		if held, err = v.Held(); err != nil || !held {
+			return err
+		}
+	}
+
+	return v.Announce()
+}
+
+// Announce sends gratuitous ARP so that neighbours update their caches to point
+// the address at this node.
+func (v *VIP) Announce() error {
+	iface, err := net.InterfaceByName(v.Interface)
+	if err != nil {
+		return err
+	}
+
+	return gratuitousARP(iface, v.Address.To4())
+}
+
+// Release removes the address from the interface if it is assigned.
+func (v *VIP) Release() error {
+	held, err := v.Held()
+	if err != nil || !held {
+		return err
+	}
+
+	log.Printf("releasing virtual IP %s on %s\n", v.Address, v.Interface)
+	return v.executor.Run("ip", "addr", "del", v.prefix(), "dev", v.Interface)
+}